@@ -0,0 +1,240 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewCachingFetcher returns a Fetcher that keeps in memory the content fetched
+// with inner, identified by base directory and path, so that it is fetched
+// only once. Failed fetches are not cached. It is safe for concurrent use.
+func NewCachingFetcher(inner Fetcher) *CachingFetcher {
+	return NewCachingFetcherTTL(inner, 0)
+}
+
+// NewCachingFetcherTTL returns a Fetcher like NewCachingFetcher, fetching again
+// the content cached for longer than ttl. A zero ttl keeps it forever. When used
+// with WithFetcher, the time is read with the function given with WithClock.
+func NewCachingFetcherTTL(inner Fetcher, ttl time.Duration) *CachingFetcher {
+	if inner == nil {
+		inner = fetcher{}
+	}
+	return &CachingFetcher{inner: inner, ttl: ttl, entries: make(map[[2]string]*cachedContent)}
+}
+
+// A CachingFetcher is a Fetcher keeping the content it fetches in memory, as
+// returned by NewCachingFetcher.
+type CachingFetcher struct {
+	inner   Fetcher
+	ttl     time.Duration // zero means no expiration.
+	mu      sync.Mutex
+	entries map[[2]string]*cachedContent // by base directory and path.
+}
+
+// cachedContent is the result of fetching a path, available once ready is
+// closed.
+type cachedContent struct {
+	ready   chan struct{}
+	b       []byte
+	mtime   time.Time
+	err     error
+	expires time.Time // zero if the content does not expire.
+}
+
+// Reset drops all the cached content, so that it is fetched again.
+func (f *CachingFetcher) Reset() {
+	f.mu.Lock()
+	f.entries = make(map[[2]string]*cachedContent)
+	f.mu.Unlock()
+}
+
+// Invalidate drops the content cached for the given base directory and path,
+// so that it is fetched again.
+func (f *CachingFetcher) Invalidate(dir, path string) {
+	f.mu.Lock()
+	delete(f.entries, [2]string{dir, path})
+	f.mu.Unlock()
+}
+
+func (f *CachingFetcher) Fetch(dir, path string) ([]byte, error) {
+	b, _, err := f.FetchModTime(dir, path)
+	return b, err
+}
+
+// FetchModTime returns the cached content and modification time of path, as
+// reported by inner if it is a ModTimeFetcher.
+func (f *CachingFetcher) FetchModTime(dir, path string) ([]byte, time.Time, error) {
+	return f.fetchAt(time.Now(), dir, path)
+}
+
+// fetchAt is like FetchModTime, with now as the current time.
+func (f *CachingFetcher) fetchAt(now time.Time, dir, path string) ([]byte, time.Time, error) {
+	key := [2]string{dir, path}
+	f.mu.Lock()
+	c, ok := f.entries[key]
+	if ok && !f.expired(c, now) {
+		f.mu.Unlock()
+		<-c.ready
+		return c.b, c.mtime, c.err
+	}
+	c = &cachedContent{ready: make(chan struct{})}
+	f.entries[key] = c
+	f.mu.Unlock()
+
+	if mf, ok := f.inner.(ModTimeFetcher); ok {
+		c.b, c.mtime, c.err = mf.FetchModTime(dir, path)
+	} else {
+		c.b, c.err = f.inner.Fetch(dir, path)
+	}
+	if f.ttl > 0 {
+		c.expires = now.Add(f.ttl)
+	}
+	if c.err != nil {
+		f.mu.Lock()
+		if f.entries[key] == c {
+			delete(f.entries, key)
+		}
+		f.mu.Unlock()
+	}
+	close(c.ready)
+	return c.b, c.mtime, c.err
+}
+
+// Head sends a HEAD request to the given URL using inner, without caching the
+// response.
+func (f *CachingFetcher) Head(url string) (*http.Response, error) {
+	return head(f.inner, url)
+}
+
+// expired reports whether the content c, if fetched, is older than the ttl at
+// the time now. Content still being fetched is not.
+func (f *CachingFetcher) expired(c *cachedContent, now time.Time) bool {
+	select {
+	case <-c.ready:
+		return !c.expires.IsZero() && !now.Before(c.expires)
+	default:
+		return false
+	}
+}
+
+// NewDiskCachingFetcher returns a Fetcher that stores the content fetched from
+// URLs in the given directory, together with its ETag and Last-Modified
+// headers. On later fetches of the same URL, a conditional request is sent
+// and the stored content is reused if the server answers 304 Not Modified.
+// Paths that are not URLs are fetched using inner, or the default Fetcher if
+// inner is nil.
+func NewDiskCachingFetcher(dir string, inner Fetcher) Fetcher {
+	if inner == nil {
+		inner = fetcher{}
+	}
+	return &diskCachingFetcher{dir: dir, inner: inner, client: http.DefaultClient}
+}
+
+type diskCachingFetcher struct {
+	dir    string
+	inner  Fetcher
+	client *http.Client
+}
+
+// Head sends a HEAD request to the given URL using inner.
+func (f *diskCachingFetcher) Head(url string) (*http.Response, error) {
+	return head(f.inner, url)
+}
+
+// cacheEntry holds the validators stored next to the cached body of a URL.
+type cacheEntry struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+func (f *diskCachingFetcher) Fetch(dir, path string) ([]byte, error) {
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		return f.inner.Fetch(dir, path)
+	}
+
+	sum := sha256.Sum256([]byte(path))
+	name := filepath.Join(f.dir, hex.EncodeToString(sum[:]))
+	var entry cacheEntry
+	body, err := ioutil.ReadFile(name + ".body")
+	if err == nil {
+		if meta, err := ioutil.ReadFile(name + ".json"); err == nil {
+			json.Unmarshal(meta, &entry)
+		}
+	}
+
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if entry.URL == path {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && entry.URL == path:
+		return body, nil
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("status %s", res.Status)
+	}
+
+	body, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	entry = cacheEntry{
+		URL:          path,
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+	}
+	if err := f.store(name, body, entry); err != nil {
+		return nil, fmt.Errorf("could not cache %s: %v", path, err)
+	}
+	return body, nil
+}
+
+func (f *diskCachingFetcher) store(name string, body []byte, entry cacheEntry) error {
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(name+".body", body, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name+".json", meta, 0644)
+}
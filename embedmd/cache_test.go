@@ -0,0 +1,172 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// versionFetcher returns the content of every path followed by the number of
+// times it was fetched.
+type versionFetcher map[string]int
+
+func (f versionFetcher) Fetch(dir, path string) ([]byte, error) {
+	f[path]++
+	return []byte(fmt.Sprintf("%s v%d\n", path, f[path])), nil
+}
+
+func TestCachingFetcherInvalidate(t *testing.T) {
+	f := NewCachingFetcher(versionFetcher{})
+	fetch := func(path, want string) {
+		t.Helper()
+		b, err := f.Fetch("", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("expected fetching %s to return %q; got %q", path, want, b)
+		}
+	}
+
+	fetch("a.go", "a.go v1\n")
+	fetch("b.go", "b.go v1\n")
+	fetch("a.go", "a.go v1\n")
+
+	f.Invalidate("", "a.go")
+	fetch("a.go", "a.go v2\n")
+	fetch("b.go", "b.go v1\n")
+
+	f.Reset()
+	fetch("a.go", "a.go v3\n")
+	fetch("b.go", "b.go v2\n")
+}
+
+func TestCachingFetcherTTL(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := NewCachingFetcherTTL(versionFetcher{}, time.Minute)
+	opts := []Option{WithFetcher(f), WithClock(func() time.Time { return now })}
+
+	for _, step := range []struct {
+		elapsed time.Duration
+		want    string
+	}{
+		{0, "a.go v1\n"},
+		{59 * time.Second, "a.go v1\n"},
+		{time.Minute, "a.go v2\n"},
+		{time.Second, "a.go v2\n"},
+	} {
+		now = now.Add(step.elapsed)
+		var out bytes.Buffer
+		if err := Process(&out, strings.NewReader("[embedmd]:# (a.go lang=text)\n"), opts...); err != nil {
+			t.Fatal(err)
+		}
+		if want := "[embedmd]:# (a.go lang=text)\n```text\n" + step.want + "```\n"; out.String() != want {
+			t.Errorf("expected embedding after %v to give %q; got %q", step.elapsed, want, out.String())
+		}
+	}
+}
+
+func TestDiskCachingFetcher(t *testing.T) {
+	var full, notModified int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, "package main\n")
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "embedmd-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 2; i++ {
+		// a new fetcher every time, as done by separate runs.
+		f := NewDiskCachingFetcher(dir, nil)
+		b, err := f.Fetch("", srv.URL+"/main.go")
+		if err != nil {
+			t.Fatalf("fetch %d: %v", i, err)
+		}
+		if string(b) != "package main\n" {
+			t.Errorf("fetch %d: expected cached body %q; got %q", i, "package main\n", b)
+		}
+	}
+	if full != 1 || notModified != 1 {
+		t.Errorf("expected one full and one conditional request; got %d and %d", full, notModified)
+	}
+}
+
+func TestDiskCachingFetcherLocalPaths(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n"}
+	b, err := NewDiskCachingFetcher("unused", files).Fetch("", "code.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "package main\n" {
+		t.Errorf("expected %q; got %q", "package main\n", b)
+	}
+}
+
+func TestMultiProcess(t *testing.T) {
+	var fetches int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		fmt.Fprint(w, "package main\n")
+	}))
+	defer srv.Close()
+
+	in := "[embedmd]:# (" + srv.URL + "/code.go lang=go)\n"
+	want := in + "```go\npackage main\n```\n"
+	outs := make([]bytes.Buffer, 3)
+	var docs []Document
+	for i := range outs {
+		docs = append(docs, Document{In: strings.NewReader(in), Out: &outs[i]})
+	}
+	if err := MultiProcess(docs); err != nil {
+		t.Fatal(err)
+	}
+	for i, out := range outs {
+		if out.String() != want {
+			t.Errorf("document %d: expected output %q; got %q", i, want, out.String())
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("expected a single fetch; got %d", fetches)
+	}
+}
+
+func TestMultiProcessError(t *testing.T) {
+	docs := []Document{
+		{In: strings.NewReader("[embedmd]:# (code.go)\n"), Out: ioutil.Discard},
+		{In: strings.NewReader("[embedmd]:# (missing.go)\n"), Out: ioutil.Discard},
+	}
+	err := MultiProcess(docs, WithFetcher(fakeFetcher{"code.go": "package main\n"}))
+	if want := "document 1: 1: could not read missing.go: "; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("expected error starting with %q; got %v", want, err)
+	}
+}
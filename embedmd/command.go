@@ -15,12 +15,48 @@ package embedmd
 
 import (
 	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
 type command struct {
+	line       int    // line of the command in the markdown document.
+	args       string // arguments of the command, as written.
 	path, lang string
+	shownPath  string // path as written in the output, see WithPathRewrite.
 	sample     string
+	start, end string // regular expressions, without the surrounding slashes.
+	begin      string // line starting the region to embed, matched literally.
+	endLine    string // line ending the region started by begin.
+	occurrence int    // index, from 1, of the match of start to embed, if not zero.
+	brace      bool
+	indent     bool // embed the more indented lines after the start one.
+	prefix     string
+	css        string // selector of the HTML element to embed.
+	goFunc     string // name of the Go or JavaScript function or method to embed.
+	structDoc  string // name of the Go struct whose fields are embedded as a table.
+	message    string // name of the protocol buffer message to embed.
+	cell       int    // number of the Jupyter notebook cell to embed, from 1.
+	gomod      bool   // embed a version from a go.mod file.
+	require    string // module whose required version is embedded.
+	since      string // git revision after which added lines are embedded.
+	out        string // path, relative to the base directory, to also write to.
+	collapse   string // summary of the details element around the content.
+	colFrom    int    // first column to embed, from 1, if not zero.
+	colTo      int    // last column to embed, if not zero.
+	highlight  string // lines to highlight, as in 1,3-5.
+	lastLine   int    // last line to highlight.
+	signature  bool
+	exported   bool // embed the exported declarations of a Go file.
+	pkgDoc     bool // embed the package doc comment of a Go file as text.
+	table      bool // render CSV content as a markdown table.
+	sort, uniq bool // sort the lines, remove the duplicated ones.
+	blame      bool // annotate lines with the commit that last changed them.
+	headers    bool // embed the response headers of the URL.
+	head, tail int  // number of lines to keep at the start or end, if not zero.
+	context    int  // number of lines to add around the region, if not zero.
 }
 
 func parseCommand(s string) (*command, error) {
@@ -38,20 +74,298 @@ func parseCommand(s string) (*command, error) {
 	}
 
 	cmd := &command{path: args[0]}
-	args = args[1:]
+	var positional []string
+	for _, arg := range args[1:] {
+		switch {
+		case arg == "raw":
+			cmd.lang = "raw"
+		case arg == "brace":
+			cmd.brace = true
+		case arg == "indentblock":
+			cmd.indent = true
+		case arg == "signature":
+			cmd.signature = true
+		case arg == "exported":
+			cmd.exported = true
+		case arg == "pkgdoc":
+			cmd.pkgDoc = true
+		case arg == "table":
+			cmd.table = true
+		case arg == "blame":
+			cmd.blame = true
+		case arg == "headers":
+			cmd.headers = true
+		case arg == "gomod":
+			cmd.gomod = true
+		case arg == "sort":
+			cmd.sort = true
+		case arg == "uniq":
+			cmd.uniq = true
+		case arg == "$" || arg[0] == '/':
+			if err := cmd.addRegexp(arg); err != nil {
+				return nil, err
+			}
+		case strings.Contains(arg, "="):
+			if err := cmd.setParam(arg); err != nil {
+				return nil, err
+			}
+		default:
+			positional = append(positional, arg)
+		}
+	}
 
-	switch {
-	case len(args) == 1:
-		cmd.sample = args[0]
-	case len(args) > 1:
+	// A lone positional argument is the name of the sample to embed, unless
+	// the part of the file to embed is selected otherwise, as in
+	// (file.go go /start/ /end/), where it is the language.
+	switch n := len(positional); {
+	case n > 2 || n == 2 && cmd.lang != "":
 		return nil, errors.New("too many arguments")
+	case n == 2:
+		cmd.lang, cmd.sample = positional[0], positional[1]
+	case n == 1 && (cmd.lang != "" || len(cmd.selectors()) == 0):
+		cmd.sample = positional[0]
+	case n == 1:
+		cmd.lang = positional[0]
 	}
 
+	if err := cmd.checkSelectors(); err != nil {
+		return nil, err
+	}
+	if (cmd.begin == "") != (cmd.endLine == "") {
+		return nil, errors.New("begin and end must be used together")
+	}
+	if cmd.require != "" && !cmd.gomod {
+		return nil, errors.New("require can only be used with gomod")
+	}
+	if cmd.head > 0 && cmd.tail > 0 {
+		return nil, errors.New("head and tail cannot be used together")
+	}
+	if cmd.context > 0 && cmd.sample == "" && cmd.start == "" && cmd.begin == "" {
+		return nil, errors.New("context requires a sample or a regexp")
+	}
+	if cmd.since != "" && cmd.blame {
+		return nil, errors.New("since cannot be used with blame")
+	}
+	if cmd.context > 0 && cmd.blame {
+		return nil, errors.New("context cannot be used with blame")
+	}
+	if cmd.occurrence > 0 && (cmd.end != "" || cmd.brace || cmd.indent) {
+		return nil, errors.New("occurrence index can only be used with a single regexp")
+	}
+	if cmd.brace && (cmd.start == "" || cmd.end != "") {
+		return nil, errors.New("brace requires a single start regexp")
+	}
+	if cmd.indent && (cmd.start == "" || cmd.end != "" || cmd.brace) {
+		return nil, errors.New("indentblock requires a single start regexp")
+	}
+	if cmd.signature && cmd.goFunc == "" {
+		return nil, errors.New("signature requires a func")
+	}
+	if cmd.signature && isJS(cmd.lang, cmd.path) {
+		return nil, errors.New("signature is only supported for Go")
+	}
+	if cmd.exported && isJS(cmd.lang, cmd.path) {
+		return nil, errors.New("exported is only supported for Go")
+	}
+	if cmd.pkgDoc && isJS(cmd.lang, cmd.path) {
+		return nil, errors.New("pkgdoc is only supported for Go")
+	}
 	return cmd, nil
 }
 
+// checkSelectors returns an error if more than one way of selecting the part
+// of the file to embed is used.
+func (c *command) checkSelectors() error {
+	used := c.selectors()
+	if len(used) < 2 {
+		return nil
+	}
+	last := len(used) - 1
+	return fmt.Errorf("%s and %s cannot be used together", strings.Join(used[:last], ", "), used[last])
+}
+
+// selectors returns the names of the ways of selecting the part of the file to
+// embed used by the command.
+func (c *command) selectors() []string {
+	var used []string
+	for _, s := range []struct {
+		name string
+		set  bool
+	}{
+		{"sample", c.sample != ""},
+		{"regexp", c.start != ""},
+		{"begin", c.begin != ""},
+		{"func", c.goFunc != ""},
+		{"structdoc", c.structDoc != ""},
+		{"exported", c.exported},
+		{"pkgdoc", c.pkgDoc},
+		{"css", c.css != ""},
+		{"message", c.message != ""},
+		{"headers", c.headers},
+		{"cell", c.cell > 0},
+		{"gomod", c.gomod},
+		{"since", c.since != ""},
+	} {
+		if s.set {
+			used = append(used, s.name)
+		}
+	}
+	return used
+}
+
+// addRegexp sets the start or end regular expression of the command, in order.
+func (c *command) addRegexp(arg string) error {
+	if strings.HasSuffix(arg, "]") {
+		i := strings.LastIndex(arg, "/[")
+		n, err := strconv.Atoi(arg[i+2 : len(arg)-1])
+		if i < 0 || err != nil || n <= 0 {
+			return fmt.Errorf("bad occurrence index in %s", arg)
+		}
+		if c.start != "" {
+			return errors.New("occurrence index can only be used with a single regexp")
+		}
+		arg, c.occurrence = arg[:i+1], n
+	}
+	switch {
+	case c.end != "":
+		return errors.New("too many regular expressions")
+	case arg == "$" && c.start == "":
+		return errors.New("$ can only be used as the end regexp")
+	case arg == "$":
+		c.end = arg
+	case arg == "//":
+		return errors.New("empty regexp")
+	case c.start == "":
+		c.start = arg[1 : len(arg)-1]
+	default:
+		c.end = arg[1 : len(arg)-1]
+	}
+	return nil
+}
+
+// setParam sets the value of a key=value argument, where the value can be
+// quoted using Go syntax.
+func (c *command) setParam(arg string) error {
+	i := strings.Index(arg, "=")
+	key, value := arg[:i], arg[i+1:]
+	if strings.HasPrefix(value, `"`) {
+		v, err := strconv.Unquote(value)
+		if err != nil {
+			return fmt.Errorf("bad quoted value for %s: %s", key, value)
+		}
+		value = v
+	}
+
+	switch key {
+	case "lang":
+		c.lang = value
+	case "prefix":
+		c.prefix = value
+	case "css":
+		c.css = value
+	case "func":
+		c.goFunc = value
+	case "structdoc":
+		c.structDoc = value
+	case "begin":
+		c.begin = value
+	case "end":
+		c.endLine = value
+	case "message":
+		c.message = value
+	case "require":
+		c.require = value
+	case "since":
+		c.since = value
+	case "out":
+		p := filepath.Clean(filepath.FromSlash(value))
+		if filepath.IsAbs(p) || p == ".." || strings.HasPrefix(p, ".."+string(filepath.Separator)) || p == "." {
+			return fmt.Errorf("out requires a path inside the base directory, got %s", value)
+		}
+		c.out = value
+	case "collapse":
+		c.collapse = value
+	case "cols":
+		if err := c.setColumns(value); err != nil {
+			return err
+		}
+	case "highlight":
+		if err := c.setHighlight(value); err != nil {
+			return err
+		}
+	case "cell":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return errors.New("cell requires a positive cell number")
+		}
+		c.cell = n
+	case "head", "tail", "context":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("%s requires a positive number of lines", key)
+		}
+		switch key {
+		case "head":
+			c.head = n
+		case "tail":
+			c.tail = n
+		default:
+			c.context = n
+		}
+	default:
+		return fmt.Errorf("unknown argument %s", key)
+	}
+	return nil
+}
+
+// setColumns sets the range of columns to embed from a value like 10:40 or 10:.
+func (c *command) setColumns(value string) error {
+	bad := fmt.Errorf("cols requires a range of columns like 10:40, got %s", value)
+	i := strings.Index(value, ":")
+	if i < 0 {
+		return bad
+	}
+	from, err := strconv.Atoi(value[:i])
+	if err != nil || from < 1 {
+		return bad
+	}
+	to := 0
+	if value[i+1:] != "" {
+		if to, err = strconv.Atoi(value[i+1:]); err != nil || to < from {
+			return bad
+		}
+	}
+	c.colFrom, c.colTo = from, to
+	return nil
+}
+
+// setHighlight sets the lines to highlight from a list of line numbers and
+// ranges of lines, such as 1,3-5.
+func (c *command) setHighlight(value string) error {
+	bad := fmt.Errorf("highlight requires line numbers or ranges like 1,3-5, got %s", value)
+	for _, r := range strings.Split(value, ",") {
+		from, to := r, r
+		if i := strings.Index(r, "-"); i >= 0 {
+			from, to = r[:i], r[i+1:]
+		}
+		a, err := strconv.Atoi(from)
+		if err != nil || a < 1 {
+			return bad
+		}
+		b, err := strconv.Atoi(to)
+		if err != nil || b < a {
+			return bad
+		}
+		if b > c.lastLine {
+			c.lastLine = b
+		}
+	}
+	c.highlight = value
+	return nil
+}
+
 // fields returns a list of the groups of text separated by blanks,
-// keeping all text surrounded by / as a group.
+// keeping all text surrounded by / or " as a group.
 func fields(s string) ([]string, error) {
 	var args []string
 
@@ -61,19 +375,46 @@ func fields(s string) ([]string, error) {
 			if sep < 0 {
 				return nil, errors.New("unbalanced /")
 			}
-			args, s = append(args, s[:sep+2]), s[sep+2:]
+			end := sep + 2
+			// include the occurrence index following a regexp, as in /regexp/[2].
+			if strings.HasPrefix(s[end:], "[") {
+				if i := strings.IndexByte(s[end:], ']'); i > 0 {
+					end += i + 1
+				}
+			}
+			args, s = append(args, s[:end]), s[end:]
 		} else {
-			sep := strings.IndexByte(s[1:], ' ')
-			if sep < 0 {
-				return append(args, s), nil
+			sep, err := nextBlank(s)
+			if err != nil {
+				return nil, err
 			}
-			args, s = append(args, s[:sep+1]), s[sep+1:]
+			args, s = append(args, s[:sep]), s[sep:]
 		}
 	}
 
 	return args, nil
 }
 
+// nextBlank will find the index of the next blank in a string that is not
+// between double quotes, or the length of the string if there is none.
+func nextBlank(s string) (int, error) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == ' ' && !quoted:
+			return i, nil
+		}
+	}
+	if quoted {
+		return 0, errors.New(`unbalanced "`)
+	}
+	return len(s), nil
+}
+
 // nextSlash will find the index of the next unescaped slash in a string.
 func nextSlash(s string) int {
 	for sep := 0; ; sep++ {
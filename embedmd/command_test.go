@@ -0,0 +1,124 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	tc := []struct {
+		name string
+		in   string
+		cmd  command
+		err  string
+	}{
+		{name: "path only", in: "(code.go)", cmd: command{path: "code.go"}},
+		{name: "language", in: "(code.go lang=go)", cmd: command{path: "code.go", lang: "go"}},
+		{name: "sample", in: "(hello.go sample)", cmd: command{path: "hello.go", sample: "sample"}},
+		{name: "language and sample", in: "(code.go go test)", cmd: command{path: "code.go", lang: "go", sample: "test"}},
+		{name: "language argument and sample", in: "(code.go lang=go test)", cmd: command{path: "code.go", lang: "go", sample: "test"}},
+		{name: "raw", in: "(shared.md raw)", cmd: command{path: "shared.md", lang: "raw"}},
+		{name: "raw sample", in: "(shared.md raw intro)", cmd: command{path: "shared.md", lang: "raw", sample: "intro"}},
+		{name: "language with regexp", in: "(code.go go /func/)", cmd: command{path: "code.go", lang: "go", start: "func"}},
+		{name: "too many arguments", in: "(code.go lang=go a b)", err: "too many arguments"},
+		{name: "start and end", in: "(code.go /func main/ /^}/)", cmd: command{path: "code.go", start: "func main", end: "^}"}},
+		{name: "start to end of file", in: "(code.go go /func/ $)", cmd: command{path: "code.go", lang: "go", start: "func", end: "$"}},
+		{name: "brace", in: "(main.c c /int main/ brace)", cmd: command{path: "main.c", lang: "c", start: "int main", brace: true}},
+		{name: "indentblock", in: "(config.yaml /^db:/ indentblock)", cmd: command{path: "config.yaml", start: "^db:", indent: true}},
+		{name: "highlight", in: "(code.go highlight=1,3-5)", cmd: command{path: "code.go", highlight: "1,3-5", lastLine: 5}},
+		{name: "bad highlight range", in: "(code.go highlight=5-3)", err: "highlight requires line numbers or ranges like 1,3-5, got 5-3"},
+		{name: "bad highlight line", in: "(code.go highlight=0,2)", err: "highlight requires line numbers or ranges like 1,3-5, got 0,2"},
+		{name: "indentblock without regexp", in: "(config.yaml indentblock)", err: "indentblock requires a single start regexp"},
+		{name: "quoted prefix", in: `(run.sh lang=sh prefix="$ ")`, cmd: command{path: "run.sh", lang: "sh", prefix: "$ "}},
+		{name: "quoted prefix with escapes", in: `(run.sh prefix="\"a b\" ")`, cmd: command{path: "run.sh", prefix: `"a b" `}},
+		{name: "unquoted prefix", in: "(run.sh prefix=>)", cmd: command{path: "run.sh", prefix: ">"}},
+		{name: "unbalanced quotes", in: `(run.sh prefix="$ )`, err: `unbalanced "`},
+		{name: "css selector", in: "(page.html html css=.example)", cmd: command{path: "page.html", lang: "html", css: ".example"}},
+		{name: "func signature", in: "(x.go go func=Foo signature)", cmd: command{path: "x.go", lang: "go", goFunc: "Foo", signature: true}},
+		{name: "js func signature", in: "(app.js func=foo signature)", err: "signature is only supported for Go"},
+		{name: "structdoc", in: "(x.go structdoc=Config)", cmd: command{path: "x.go", structDoc: "Config"}},
+		{name: "signature without func", in: "(x.go go signature)", err: "signature requires a func"},
+		{name: "unknown argument", in: "(run.sh foo=bar)", err: "unknown argument foo"},
+		{name: "brace without regexp", in: "(main.c c brace)", err: "brace requires a single start regexp"},
+		{name: "brace with end regexp", in: "(main.c /a/ /b/ brace)", err: "brace requires a single start regexp"},
+		{name: "dollar as start", in: "(code.go $)", err: "$ can only be used as the end regexp"},
+		{name: "too many regexps", in: "(code.go /a/ /b/ /c/)", err: "too many regular expressions"},
+		{name: "too many arguments", in: "(code.go go test extra)", err: "too many arguments"},
+		{name: "sample and regexp", in: "(code.go go test /a/)", err: "sample and regexp cannot be used together"},
+		{name: "sample and func", in: "(x.go go test func=Foo)", err: "sample and func cannot be used together"},
+		{name: "regexp and css", in: "(page.html /a/ css=div)", err: "regexp and css cannot be used together"},
+		{name: "func and message", in: "(x.go func=Foo message=Bar)", err: "func and message cannot be used together"},
+		{name: "func and structdoc", in: "(x.go func=Foo structdoc=Config)", err: "func and structdoc cannot be used together"},
+		{name: "exported", in: "(x.go exported)", cmd: command{path: "x.go", exported: true}},
+		{name: "func and exported", in: "(x.go func=Foo exported)", err: "func and exported cannot be used together"},
+		{name: "pkgdoc", in: "(x.go pkgdoc)", cmd: command{path: "x.go", pkgDoc: true}},
+		{name: "begin and end", in: `(x.txt text begin="--- begin ---" end="--- end ---")`, cmd: command{path: "x.txt", lang: "text", begin: "--- begin ---", endLine: "--- end ---"}},
+		{name: "begin without end", in: `(x.txt begin="--- begin ---")`, err: "begin and end must be used together"},
+		{name: "begin and regexp", in: `(x.txt /a/ begin=a end=b)`, err: "regexp and begin cannot be used together"},
+		{name: "exported and pkgdoc", in: "(x.go exported pkgdoc)", err: "exported and pkgdoc cannot be used together"},
+		{name: "three selectors", in: "(x.go go test /a/ func=Foo)", err: "sample, regexp and func cannot be used together"},
+		{name: "head", in: "(code.go head=5)", cmd: command{path: "code.go", head: 5}},
+		{name: "tail", in: "(code.go go test tail=3)", cmd: command{path: "code.go", lang: "go", sample: "test", tail: 3}},
+		{name: "head and tail", in: "(code.go head=5 tail=3)", err: "head and tail cannot be used together"},
+		{name: "bad head", in: "(code.go head=five)", err: "head requires a positive number of lines"},
+		{name: "zero tail", in: "(code.go tail=0)", err: "tail requires a positive number of lines"},
+		{name: "context", in: "(code.go go test context=2)", cmd: command{path: "code.go", lang: "go", sample: "test", context: 2}},
+		{name: "context without region", in: "(code.go context=2)", err: "context requires a sample or a regexp"},
+		{name: "context with blame", in: "(code.go go test context=2 blame)", err: "context cannot be used with blame"},
+		{name: "sort and uniq", in: "(keys.txt lang=text sort uniq)", cmd: command{path: "keys.txt", lang: "text", sort: true, uniq: true}},
+		{name: "since", in: "(CHANGES.md since=v1.0)", cmd: command{path: "CHANGES.md", since: "v1.0"}},
+		{name: "out", in: "(src.go lang=go out=snippets/foo.go)", cmd: command{path: "src.go", lang: "go", out: "snippets/foo.go"}},
+		{name: "out traversal", in: "(src.go out=../foo.go)", err: "out requires a path inside the base directory, got ../foo.go"},
+		{name: "out traversal after clean", in: "(src.go out=snippets/../../foo.go)", err: "out requires a path inside the base directory, got snippets/../../foo.go"},
+		{name: "out absolute", in: "(src.go out=/tmp/foo.go)", err: "out requires a path inside the base directory, got /tmp/foo.go"},
+		{name: "since with blame", in: "(CHANGES.md since=v1.0 blame)", err: "since cannot be used with blame"},
+		{name: "since and regexp", in: "(CHANGES.md /a/ since=v1.0)", err: "regexp and since cannot be used together"},
+		{name: "notebook cell", in: "(nb.ipynb python cell=3)", cmd: command{path: "nb.ipynb", lang: "python", cell: 3}},
+		{name: "bad notebook cell", in: "(nb.ipynb python cell=first)", err: "cell requires a positive cell number"},
+		{name: "gomod", in: "(go.mod gomod)", cmd: command{path: "go.mod", gomod: true}},
+		{name: "gomod require", in: "(go.mod gomod require=golang.org/x/mod)", cmd: command{path: "go.mod", gomod: true, require: "golang.org/x/mod"}},
+		{name: "require without gomod", in: "(go.mod require=golang.org/x/mod)", err: "require can only be used with gomod"},
+		{name: "occurrence", in: "(code.go go /func/[2])", cmd: command{path: "code.go", lang: "go", start: "func", occurrence: 2}},
+		{name: "occurrence with end", in: "(code.go /func/[2] /}/)", err: "occurrence index can only be used with a single regexp"},
+		{name: "occurrence of end", in: "(code.go /func/ /}/[2])", err: "occurrence index can only be used with a single regexp"},
+		{name: "bad occurrence", in: "(code.go /func/[x])", err: "bad occurrence index in /func/[x]"},
+		{name: "zero occurrence", in: "(code.go /func/[0])", err: "bad occurrence index in /func/[0]"},
+		{name: "columns", in: "(data.txt cols=10:40)", cmd: command{path: "data.txt", colFrom: 10, colTo: 40}},
+		{name: "columns to the end", in: "(data.txt cols=3:)", cmd: command{path: "data.txt", colFrom: 3}},
+		{name: "bad columns", in: "(data.txt cols=40:10)", err: "cols requires a range of columns like 10:40, got 40:10"},
+		{name: "columns without range", in: "(data.txt cols=10)", err: "cols requires a range of columns like 10:40, got 10"},
+		{name: "missing parenthesis", in: "code.go", err: "argument list should be in parenthesis"},
+		{name: "missing file name", in: "()", err: "missing file name"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := parseCommand(tt.in)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("case [%s]: unexpected error %v", tt.name, err)
+			}
+			if !reflect.DeepEqual(*cmd, tt.cmd) {
+				t.Errorf("case [%s]: expected %+v; got %+v", tt.name, tt.cmd, *cmd)
+			}
+		})
+	}
+}
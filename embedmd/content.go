@@ -14,11 +14,22 @@
 package embedmd
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"go/build"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Fetcher provides an abstraction on a file system.
@@ -31,15 +42,341 @@ type Fetcher interface {
 	Fetch(dir, path string) ([]byte, error)
 }
 
-type fetcher struct{}
+// A ModTimeFetcher is a Fetcher that can also report when the fetched content
+// was last modified. The zero time indicates that it is unknown.
+type ModTimeFetcher interface {
+	Fetcher
+	FetchModTime(dir, path string) ([]byte, time.Time, error)
+}
+
+// A HeadFetcher is a Fetcher that can also send HEAD requests to URLs, whose
+// response headers are embedded by the headers keyword.
+type HeadFetcher interface {
+	Fetcher
+	Head(url string) (*http.Response, error)
+}
+
+// head sends a HEAD request to url using f, which must be a HeadFetcher.
+func head(f Fetcher, url string) (*http.Response, error) {
+	hf, ok := f.(HeadFetcher)
+	if !ok {
+		return nil, errors.New("headers requires a HeadFetcher")
+	}
+	return hf.Head(url)
+}
+
+type fetcher struct {
+	client       *http.Client      // if nil, http.DefaultClient is used.
+	auth         map[string]string // Authorization header values by host.
+	insecureAuth bool
+	blobs        map[string]BlobFetcher // by URL scheme.
+	readTimeout  time.Duration          // zero means no timeout.
+	validateURL  func(*url.URL) (*url.URL, error)
+	accept       string // value of the Accept header, if not empty.
+	named        map[string]*namedSource
+}
+
+// A namedSource is a source registered with WithNamedSource. Its reader is read
+// the first time it is fetched, and its content kept for the next ones.
+type namedSource struct {
+	once sync.Once
+	r    io.Reader
+	b    []byte
+	err  error
+}
+
+// content returns the content of the source.
+func (s *namedSource) content() ([]byte, error) {
+	s.once.Do(func() {
+		s.b, s.err = ioutil.ReadAll(s.r)
+		s.r = nil
+	})
+	// Appending to the content must not modify the buffer shared by all the
+	// commands embedding it.
+	return s.b[:len(s.b):len(s.b)], s.err
+}
+
+// decodeDataURI returns the content of a data URI, such as
+// data:text/plain;base64,SGVsbG8K or data:,Hello%0A.
+func decodeDataURI(uri string) ([]byte, error) {
+	i := strings.Index(uri, ",")
+	if i < 0 {
+		return nil, errors.New("missing comma in data URI")
+	}
+	meta, data := uri[len("data:"):i], uri[i+1:]
+	if strings.HasSuffix(meta, ";base64") {
+		b, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("bad base64 data URI: %v", err)
+		}
+		return b, nil
+	}
+	s, err := url.PathUnescape(data)
+	if err != nil {
+		return nil, fmt.Errorf("bad data URI: %v", err)
+	}
+	return []byte(s), nil
+}
+
+// An idleTimeoutReader fails reading if no data is received for some time,
+// cancelling the request the data is read from.
+type idleTimeoutReader struct {
+	r        io.Reader
+	d        time.Duration
+	timer    *time.Timer
+	timedOut int32 // set atomically when the timer fires.
+}
+
+func newIdleTimeoutReader(r io.Reader, d time.Duration, cancel func()) *idleTimeoutReader {
+	t := &idleTimeoutReader{r: r, d: d}
+	t.timer = time.AfterFunc(d, func() {
+		atomic.StoreInt32(&t.timedOut, 1)
+		cancel()
+	})
+	return t
+}
+
+func (t *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if atomic.LoadInt32(&t.timedOut) == 1 {
+		return n, fmt.Errorf("no data received for %v", t.d)
+	}
+	if n > 0 {
+		t.timer.Reset(t.d)
+	}
+	return n, err
+}
+
+// stop stops the timer once reading is done.
+func (t *idleTimeoutReader) stop() { t.timer.Stop() }
+
+// A BlobFetcher fetches objects from a storage service, such as S3 or GCS, for
+// URLs like s3://bucket/key. See WithBlobFetcher.
+type BlobFetcher interface {
+	FetchBlob(bucket, key string) ([]byte, error)
+}
+
+// urlScheme returns the scheme of the given path if it is a URL, such as s3
+// for s3://bucket/key, or "" otherwise.
+func urlScheme(path string) string {
+	i := strings.Index(path, "://")
+	if i <= 0 {
+		return ""
+	}
+	for j, r := range path[:i] {
+		letter := 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'
+		if !letter && (j == 0 || !strings.ContainsRune("0123456789+-.", r)) {
+			return ""
+		}
+	}
+	return strings.ToLower(path[:i])
+}
+
+// fetchBlob fetches the object identified by the given URL with the
+// BlobFetcher registered for its scheme.
+func (f fetcher) fetchBlob(scheme, uri string) ([]byte, error) {
+	bf, ok := f.blobs[scheme]
+	if !ok {
+		return nil, fmt.Errorf("no fetcher registered for scheme %s", scheme)
+	}
+	rest := uri[len(scheme)+len("://"):]
+	i := strings.Index(rest, "/")
+	if i <= 0 || i == len(rest)-1 {
+		return nil, fmt.Errorf("%s should have the form %s://bucket/key", uri, scheme)
+	}
+	return bf.FetchBlob(rest[:i], rest[i+1:])
+}
 
-func (fetcher) Fetch(dir, path string) ([]byte, error) {
+// defaultFetcher returns the Fetcher used unless WithFetcher is given.
+func (e *embedder) defaultFetcher() Fetcher {
+	f := fetcher{
+		auth:         e.auth,
+		insecureAuth: e.insecureAuth,
+		blobs:        e.blobFetchers,
+		readTimeout:  e.readTimeout,
+		validateURL:  e.validateURL,
+		accept:       e.accept,
+		named:        e.namedSources,
+	}
+	f.client = &http.Client{CheckRedirect: checkRedirects(e.maxRedirects, e.validateURL, f.authorize)}
+	if e.maxConnsPerHost > 0 || e.proxy != nil || e.hostOverride != nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.MaxConnsPerHost = e.maxConnsPerHost
+		if e.proxy != nil {
+			t.Proxy = e.proxy
+		}
+		if e.hostOverride != nil {
+			t.DialContext = overrideHosts(e.hostOverride)
+		}
+		f.client.Transport = t
+	}
+	return f
+}
+
+// overrideHosts returns a DialContext function for http.Transport connecting
+// to the address given in overrides for the host, or host and port, being
+// dialed, and to the host itself when there is none. Overrides without a port
+// keep the one dialed.
+func overrideHosts(overrides map[string]string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		to, ok := overrides[addr]
+		if !ok {
+			if host, port, err := net.SplitHostPort(addr); err == nil {
+				if to, ok = overrides[host]; ok {
+					if _, _, err := net.SplitHostPort(to); err != nil {
+						to = net.JoinHostPort(to, port)
+					}
+				}
+			}
+		}
+		if ok {
+			addr = to
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+}
+
+// checkRedirects returns an http.Client CheckRedirect function failing after n
+// redirects. If validate is not nil, the URLs redirected to go through it like
+// the ones requested first. The Authorization header copied by the client is
+// dropped, and set again by authorize for the URL redirected to, so that
+// credentials are only sent to the hosts they were given for.
+func checkRedirects(n int, validate func(*url.URL) (*url.URL, error), authorize func(*http.Request) error) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > n {
+			return fmt.Errorf("stopped after %d redirects", n)
+		}
+		if validate != nil {
+			u, err := validate(req.URL)
+			if err != nil {
+				return err
+			}
+			req.URL, req.Host = u, u.Host
+		}
+		req.Header.Del("Authorization")
+		return authorize(req)
+	}
+}
+
+func (f fetcher) Fetch(dir, path string) ([]byte, error) {
+	b, _, err := f.FetchModTime(dir, path)
+	return b, err
+}
+
+// FetchModTime fetches the given path, returning the modification time of
+// local files and the Last-Modified header of URLs.
+func (f fetcher) FetchModTime(dir, path string) ([]byte, time.Time, error) {
+	if strings.HasPrefix(path, "data:") {
+		b, err := decodeDataURI(path)
+		return b, time.Time{}, err
+	}
+	if strings.HasPrefix(path, "mod:") {
+		b, err := ModuleFetcher{}.Fetch(dir, path)
+		return b, time.Time{}, err
+	}
+	if strings.HasPrefix(path, "named:") {
+		name := strings.TrimPrefix(path, "named:")
+		s, ok := f.named[name]
+		if !ok {
+			return nil, time.Time{}, fmt.Errorf("no source named %s", name)
+		}
+		b, err := s.content()
+		return b, time.Time{}, err
+	}
+	if scheme := urlScheme(path); scheme != "" && scheme != "http" && scheme != "https" {
+		b, err := f.fetchBlob(scheme, path)
+		return b, time.Time{}, err
+	}
 	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
-		path = filepath.Join(dir, filepath.FromSlash(path))
-		return ioutil.ReadFile(path)
+		path = localPath(dir, path)
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		var mtime time.Time
+		if fi, err := os.Stat(path); err == nil {
+			mtime = fi.ModTime()
+		}
+		return b, mtime, nil
+	}
+
+	client := f.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := f.newRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if err := f.authorize(req); err != nil {
+		return nil, time.Time{}, err
+	}
+	var cancel context.CancelFunc = func() {}
+	if f.readTimeout > 0 {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(req.Context())
+		req = req.WithContext(ctx)
+	}
+	defer cancel()
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("status %s", res.Status)
+	}
+	var body io.Reader = res.Body
+	if f.readTimeout > 0 {
+		r := newIdleTimeoutReader(res.Body, f.readTimeout, cancel)
+		defer r.stop()
+		body = r
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	mtime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	return b, mtime, nil
+}
+
+// Head sends a HEAD request to the given URL, with the same configuration as
+// the requests sent by Fetch.
+func (f fetcher) Head(url string) (*http.Response, error) {
+	client := f.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := f.newRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return nil, err
 	}
+	if err := f.authorize(req); err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
 
-	res, err := http.Get(path)
+// post sends body to the given URL in a POST request, with the same
+// configuration as the requests sent by Fetch, and returns the body of the
+// response. The request is canceled when ctx is done.
+func (f fetcher) post(ctx context.Context, url, contentType string, body io.Reader) ([]byte, error) {
+	client := f.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := f.newRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if err := f.authorize(req); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	res, err := client.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -47,5 +384,185 @@ func (fetcher) Fetch(dir, path string) ([]byte, error) {
 	if res.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("status %s", res.Status)
 	}
-	return ioutil.ReadAll(res.Body)
+	var r io.Reader = res.Body
+	if f.readTimeout > 0 {
+		tr := newIdleTimeoutReader(res.Body, f.readTimeout, cancel)
+		defer tr.stop()
+		r = tr
+	}
+	return ioutil.ReadAll(r)
+}
+
+// newRequest returns a request to the given URL, as rewritten by the function
+// given with WithValidateURL, with the Accept header given with
+// WithAcceptHeader.
+func (f fetcher) newRequest(method, rawURL string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if f.validateURL != nil {
+		u, err := f.validateURL(req.URL)
+		if err != nil {
+			return nil, err
+		}
+		req.URL, req.Host = u, u.Host
+	}
+	if f.accept != "" {
+		req.Header.Set("Accept", f.accept)
+	}
+	return req, nil
+}
+
+// localPath returns the path of a local file relative to the base directory
+// dir. Back slashes, used by commands written on Windows, are accepted as
+// separators like forward slashes.
+func localPath(dir, path string) string {
+	return filepath.Join(dir, filepath.FromSlash(strings.Replace(path, `\`, "/", -1)))
+}
+
+// isLocalPath reports whether the given command path names a local file,
+// rather than a URL, a data: URI, a Go module or a named source.
+func isLocalPath(path string) bool {
+	return urlScheme(path) == "" && !strings.HasPrefix(path, "data:") && !strings.HasPrefix(path, "mod:") && !strings.HasPrefix(path, "named:")
+}
+
+// authorize adds the Authorization header registered for the host of the
+// request, if any. The http.Client drops it on redirects to other hosts.
+func (f fetcher) authorize(req *http.Request) error {
+	v, ok := f.auth[req.URL.Host]
+	if !ok {
+		v, ok = f.auth[req.URL.Hostname()]
+	}
+	if !ok {
+		return nil
+	}
+	if req.URL.Scheme != "https" && !f.insecureAuth {
+		return fmt.Errorf("refusing to send credentials for %s over %s", req.URL.Host, req.URL.Scheme)
+	}
+	req.Header.Set("Authorization", v)
+	return nil
+}
+
+// ModuleFetcher is a Fetcher reading files from the Go module cache. Paths
+// have the form mod:module@version/path/to/file, for instance:
+//
+//	mod:github.com/pmezard/go-difflib@v1.0.0/difflib/difflib.go
+//
+// Paths without the mod: prefix are fetched by the default Fetcher.
+type ModuleFetcher struct {
+	// Dir is the module cache directory. If empty, $GOMODCACHE is used, and
+	// if that is not set either, the pkg/mod directory in the first entry of
+	// GOPATH.
+	Dir string
+}
+
+// Fetch reads the file identified by the given mod: path from the cache.
+func (f ModuleFetcher) Fetch(dir, path string) ([]byte, error) {
+	if !strings.HasPrefix(path, "mod:") {
+		return fetcher{}.Fetch(dir, path)
+	}
+
+	mod := strings.TrimPrefix(path, "mod:")
+	at := strings.Index(mod, "@")
+	if at <= 0 {
+		return nil, fmt.Errorf("missing version in module path %q", path)
+	}
+	slash := strings.Index(mod[at:], "/")
+	if slash < 0 {
+		return nil, fmt.Errorf("missing file in module path %q", path)
+	}
+	name, version, file := mod[:at], mod[at+1:at+slash], mod[at+slash+1:]
+	if version == "" || file == "" {
+		return nil, fmt.Errorf("invalid module path %q", path)
+	}
+
+	cache := f.Dir
+	if cache == "" {
+		cache = moduleCacheDir()
+	}
+	root := filepath.Join(cache, filepath.FromSlash(escapeModulePath(name)+"@"+escapeModulePath(version)))
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil, fmt.Errorf("module %s@%s is not in the module cache, run go mod download %s@%s", name, version, name, version)
+	}
+	return ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(file)))
+}
+
+// Head sends a HEAD request to the given URL using the default Fetcher.
+func (f ModuleFetcher) Head(url string) (*http.Response, error) {
+	return fetcher{}.Head(url)
+}
+
+// moduleCacheDir returns the default location of the module cache.
+func moduleCacheDir() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	gopath := filepath.SplitList(build.Default.GOPATH)
+	if len(gopath) == 0 {
+		return ""
+	}
+	return filepath.Join(gopath[0], "pkg", "mod")
+}
+
+// escapeModulePath escapes upper case letters as the module cache does,
+// replacing them with an exclamation mark followed by the lower case letter.
+func escapeModulePath(s string) string {
+	var buf strings.Builder
+	for _, r := range s {
+		if 'A' <= r && r <= 'Z' {
+			buf.WriteByte('!')
+			r += 'a' - 'A'
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// NewChainFetcher returns a Fetcher trying each of the given fetchers in order
+// and returning the content from the first one succeeding. If all of them
+// fail, the error lists the failure of each of them, and reports a missing
+// file, as handled by WithMissingFilePolicy, if none of them found it.
+func NewChainFetcher(fetchers ...Fetcher) Fetcher {
+	return chainFetcher(fetchers)
+}
+
+type chainFetcher []Fetcher
+
+func (c chainFetcher) Fetch(dir, path string) ([]byte, error) {
+	if len(c) == 0 {
+		return nil, fmt.Errorf("no fetchers to fetch %s", path)
+	}
+	cerr := &chainError{msgs: make([]string, len(c)), notExist: true}
+	for i, f := range c {
+		b, err := f.Fetch(dir, path)
+		if err == nil {
+			return b, nil
+		}
+		cerr.msgs[i] = err.Error()
+		cerr.notExist = cerr.notExist && errors.Is(err, os.ErrNotExist)
+	}
+	return nil, cerr
+}
+
+// A chainError lists the failures of the fetchers of a chainFetcher. It is an
+// os.ErrNotExist error when the content does not exist for any of them.
+type chainError struct {
+	msgs     []string
+	notExist bool
+}
+
+func (e *chainError) Error() string { return "all fetchers failed: " + strings.Join(e.msgs, "; ") }
+
+func (e *chainError) Is(target error) bool { return target == os.ErrNotExist && e.notExist }
+
+// Head sends a HEAD request to the given URL using the first of the fetchers
+// that is a HeadFetcher.
+func (c chainFetcher) Head(url string) (*http.Response, error) {
+	for _, f := range c {
+		if hf, ok := f.(HeadFetcher); ok {
+			return hf.Head(url)
+		}
+	}
+	return nil, fmt.Errorf("no fetchers can send HEAD requests to %s", url)
 }
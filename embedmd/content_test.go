@@ -0,0 +1,735 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestModuleFetcher(t *testing.T) {
+	cache, err := ioutil.TempDir("", "embedmd-modcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cache)
+
+	dir := filepath.Join(cache, "github.com", "!foo", "bar@v1.2.3")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "baz.go"), []byte("package bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tc := []struct {
+		name string
+		path string
+		out  string
+		err  string
+	}{
+		{name: "cached file", path: "mod:github.com/Foo/bar@v1.2.3/baz.go", out: "package bar\n"},
+		{name: "version not downloaded", path: "mod:github.com/Foo/bar@v1.3.0/baz.go",
+			err: "module github.com/Foo/bar@v1.3.0 is not in the module cache, run go mod download github.com/Foo/bar@v1.3.0"},
+		{name: "missing version", path: "mod:github.com/Foo/bar/baz.go", err: `missing version in module path "mod:github.com/Foo/bar/baz.go"`},
+		{name: "missing file", path: "mod:github.com/Foo/bar@v1.2.3", err: `missing file in module path "mod:github.com/Foo/bar@v1.2.3"`},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := ModuleFetcher{Dir: cache}.Fetch("", tt.path)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.out {
+				t.Errorf("case [%s]: expected %q; got %q", tt.name, tt.out, b)
+			}
+		})
+	}
+}
+
+type failingFetcher string
+
+func (f failingFetcher) Fetch(dir, path string) ([]byte, error) { return nil, errors.New(string(f)) }
+
+func TestChainFetcher(t *testing.T) {
+	mirror := fakeFetcher{"code.go": "package mirror\n"}
+	tc := []struct {
+		name     string
+		fetchers []Fetcher
+		out      string
+		err      string
+	}{
+		{
+			name:     "first fails",
+			fetchers: []Fetcher{failingFetcher("offline"), mirror},
+			out:      "package mirror\n",
+		},
+		{
+			name:     "first succeeds",
+			fetchers: []Fetcher{mirror, failingFetcher("unused")},
+			out:      "package mirror\n",
+		},
+		{
+			name:     "all fail",
+			fetchers: []Fetcher{failingFetcher("offline"), failingFetcher("not found")},
+			err:      "all fetchers failed: offline; not found",
+		},
+		{
+			name: "no fetchers",
+			err:  "no fetchers to fetch code.go",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := NewChainFetcher(tt.fetchers...).Fetch("", "code.go")
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.out {
+				t.Errorf("case [%s]: expected %q; got %q", tt.name, tt.out, b)
+			}
+		})
+	}
+}
+
+func TestChainFetcherMissingFile(t *testing.T) {
+	in := "[embedmd]:# (code.go)\n"
+	chain := NewChainFetcher(fakeFetcher{}, fakeFetcher{})
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithFetcher(chain), WithMissingFilePolicy(MissingFileSkip)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != in {
+		t.Errorf("expected output %q; got %q", in, out.String())
+	}
+
+	// the file may exist when one of the fetchers fails otherwise.
+	chain = NewChainFetcher(failingFetcher("offline"), fakeFetcher{})
+	err := Process(&out, strings.NewReader(in), WithFetcher(chain), WithMissingFilePolicy(MissingFileSkip))
+	if want := "1: could not read code.go: all fetchers failed: offline; file does not exist"; err == nil || err.Error() != want {
+		t.Errorf("expected error %q; got %v", want, err)
+	}
+}
+
+func TestMaxConnsPerHost(t *testing.T) {
+	var mu sync.Mutex
+	active, max := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		if active > max {
+			max = active
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		fmt.Fprintln(w, "package main")
+	}))
+	defer srv.Close()
+
+	f := newEmbedder(WithMaxConnsPerHost(2)).Fetcher
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := f.Fetch("", srv.URL+"/code.go"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if max > 2 {
+		t.Errorf("expected at most 2 simultaneous connections; got %d", max)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "proxied %s\n", r.URL)
+	}))
+	defer proxy.Close()
+	direct := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "direct")
+	}))
+	defer direct.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var consulted []string
+	f := newEmbedder(WithProxy(func(r *http.Request) (*url.URL, error) {
+		consulted = append(consulted, r.URL.Host)
+		if r.URL.Host == "internal.example" {
+			return proxyURL, nil
+		}
+		return nil, nil
+	})).Fetcher
+
+	b, err := f.Fetch("", "http://internal.example/code.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "proxied http://internal.example/code.go\n"; string(b) != want {
+		t.Errorf("expected %q through the proxy; got %q", want, b)
+	}
+	if b, err = f.Fetch("", direct.URL+"/code.go"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "direct\n"; string(b) != want {
+		t.Errorf("expected %q bypassing the proxy; got %q", want, b)
+	}
+	if want := []string{"internal.example", strings.TrimPrefix(direct.URL, "http://")}; !reflect.DeepEqual(consulted, want) {
+		t.Errorf("expected the proxy function to be consulted for %q; got %q", want, consulted)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved.go":
+			http.Redirect(w, r, "http://docs.example/code.go", http.StatusFound)
+		case "/escape.go":
+			http.Redirect(w, r, "http://blocked.example/code.go", http.StatusFound)
+		default:
+			fmt.Fprintf(w, "%s?%s\n", r.URL.Path, r.URL.RawQuery)
+		}
+	}))
+	defer srv.Close()
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := newEmbedder(WithValidateURL(func(u *url.URL) (*url.URL, error) {
+		if u.Host == "blocked.example" {
+			return nil, errors.New("host not allowed")
+		}
+		rewritten := *u
+		rewritten.Scheme, rewritten.Host = target.Scheme, target.Host
+		rewritten.RawQuery = "token=secret"
+		return &rewritten, nil
+	})).Fetcher
+
+	b, err := f.Fetch("", "http://docs.example/code.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/code.go?token=secret\n"; string(b) != want {
+		t.Errorf("expected %q from the rewritten URL; got %q", want, b)
+	}
+	if _, err := f.Fetch("", "http://blocked.example/code.go"); err == nil || err.Error() != "host not allowed" {
+		t.Errorf("expected the URL to be rejected; got %v", err)
+	}
+
+	b, err = f.Fetch("", "http://docs.example/moved.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/code.go?token=secret\n"; string(b) != want {
+		t.Errorf("expected %q from the rewritten redirect; got %q", want, b)
+	}
+	if _, err := f.Fetch("", "http://docs.example/escape.go"); err == nil || !strings.HasSuffix(err.Error(), "host not allowed") {
+		t.Errorf("expected the redirect to be rejected; got %v", err)
+	}
+}
+
+func TestAcceptHeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") == "text/plain" {
+			fmt.Fprintln(w, "package main")
+			return
+		}
+		fmt.Fprintln(w, "<html><body>package main</body></html>")
+	}))
+	defer srv.Close()
+
+	tc := []struct {
+		name   string
+		accept string
+		out    string
+	}{
+		{name: "default", out: "<html><body>package main</body></html>\n"},
+		{name: "text/plain", accept: "text/plain", out: "package main\n"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := newEmbedder(WithAcceptHeader(tt.accept)).Fetch("", srv.URL+"/code.go")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.out {
+				t.Errorf("case [%s]: expected %q; got %q", tt.name, tt.out, b)
+			}
+		})
+	}
+}
+
+func TestNamedSource(t *testing.T) {
+	r := &countingReader{r: strings.NewReader("version: 1.2.3\n")}
+	in := "[embedmd]:# (named:buildinfo lang=text)\n\n[embedmd]:# (named:buildinfo text /version: .*/)\n"
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithNamedSource("buildinfo", r)); err != nil {
+		t.Fatal(err)
+	}
+	want := "[embedmd]:# (named:buildinfo lang=text)\n```text\nversion: 1.2.3\n```\n\n" +
+		"[embedmd]:# (named:buildinfo text /version: .*/)\n```text\nversion: 1.2.3\n```\n"
+	if out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+	if r.eof != 1 {
+		t.Errorf("expected the source to be read once; got %d times", r.eof)
+	}
+
+	err := Process(ioutil.Discard, strings.NewReader("[embedmd]:# (named:other lang=text)\n"), WithNamedSource("buildinfo", r))
+	if want := "1: could not read named:other: no source named other"; err == nil || err.Error() != want {
+		t.Errorf("expected error %q; got %v", want, err)
+	}
+}
+
+// countingReader counts the times r is read to the end.
+type countingReader struct {
+	r   io.Reader
+	eof int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err == io.EOF {
+		c.eof++
+	}
+	return n, err
+}
+
+func TestHostOverride(t *testing.T) {
+	var hosts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		fmt.Fprintln(w, "package staging")
+	}))
+	defer srv.Close()
+	addr := srv.Listener.Addr().String()
+	_, port, _ := net.SplitHostPort(addr)
+
+	tc := []struct {
+		name      string
+		overrides map[string]string
+		url       string
+	}{
+		{name: "host", overrides: map[string]string{"example.com": addr}, url: "http://example.com/code.go"},
+		{name: "host and port", overrides: map[string]string{"example.com:80": addr}, url: "http://example.com/code.go"},
+		{name: "override without port", overrides: map[string]string{"example.com": "127.0.0.1"}, url: "http://example.com:" + port + "/code.go"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts = nil
+			b, err := newEmbedder(WithHostOverride(tt.overrides)).Fetch("", tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "package staging\n"; string(b) != want {
+				t.Errorf("case [%s]: expected %q; got %q", tt.name, want, b)
+			}
+			u, _ := url.Parse(tt.url)
+			if len(hosts) != 1 || hosts[0] != u.Host {
+				t.Errorf("case [%s]: expected a request for host %s; got %q", tt.name, u.Host, hosts)
+			}
+		})
+	}
+}
+
+func TestMaxRedirects(t *testing.T) {
+	// The server redirects /n to /n-1, and serves /0.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if n == 0 {
+			fmt.Fprintln(w, "package main")
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/%d", n-1), http.StatusFound)
+	}))
+	defer srv.Close()
+
+	tc := []struct {
+		name string
+		opts []Option
+		path string
+		err  string
+	}{
+		{name: "default", path: "/10"},
+		{name: "default exceeded", path: "/11", err: "stopped after 10 redirects"},
+		{name: "limit", opts: []Option{WithMaxRedirects(2)}, path: "/2"},
+		{name: "limit exceeded", opts: []Option{WithMaxRedirects(2)}, path: "/3", err: "stopped after 2 redirects"},
+		{name: "no redirects", opts: []Option{WithMaxRedirects(0)}, path: "/1", err: "stopped after 0 redirects"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := newEmbedder(tt.opts...).Fetch("", srv.URL+tt.path)
+			if tt.err != "" {
+				if err == nil || !strings.HasSuffix(err.Error(), tt.err) {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "package main\n"; string(b) != want {
+				t.Errorf("case [%s]: expected %q; got %q", tt.name, want, b)
+			}
+		})
+	}
+}
+
+func TestAuth(t *testing.T) {
+	var got []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Authorization"))
+		fmt.Fprintln(w, "package main")
+	}
+	srv := httptest.NewServer(http.HandlerFunc(handler))
+	defer srv.Close()
+	other := httptest.NewServer(http.HandlerFunc(handler))
+	defer other.Close()
+	tls := httptest.NewTLSServer(http.HandlerFunc(handler))
+	defer tls.Close()
+	host := func(s *httptest.Server) string { return s.Listener.Addr().String() }
+
+	tc := []struct {
+		name string
+		opts []Option
+		url  string
+		auth string
+		err  string
+	}{
+		{
+			name: "configured host",
+			opts: []Option{WithAuth(host(srv), "Bearer", "token"), WithInsecureAuth(true)},
+			url:  srv.URL,
+			auth: "Bearer token",
+		},
+		{
+			name: "other host",
+			opts: []Option{WithAuth(host(srv), "Bearer", "token"), WithInsecureAuth(true)},
+			url:  other.URL,
+		},
+		{
+			name: "several hosts",
+			opts: []Option{WithAuth(host(srv), "Bearer", "token"), WithAuth(host(other), "Basic", "dXNlcjpwYXNz"), WithInsecureAuth(true)},
+			url:  other.URL,
+			auth: "Basic dXNlcjpwYXNz",
+		},
+		{
+			name: "plain http",
+			opts: []Option{WithAuth(host(srv), "Bearer", "token")},
+			url:  srv.URL,
+			err:  "refusing to send credentials for " + host(srv) + " over http",
+		},
+		{
+			name: "https",
+			opts: []Option{WithAuth(host(tls), "Bearer", "token")},
+			url:  tls.URL,
+			auth: "Bearer token",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			f := newEmbedder(tt.opts...).Fetcher.(fetcher)
+			if f.client.Transport == nil {
+				f.client.Transport = tls.Client().Transport
+			}
+			_, err := f.Fetch("", tt.url+"/code.go")
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				if len(got) != 0 {
+					t.Errorf("case [%s]: expected no request; got %d", tt.name, len(got))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || got[0] != tt.auth {
+				t.Errorf("case [%s]: expected Authorization %q; got %q", tt.name, tt.auth, got)
+			}
+		})
+	}
+}
+
+func TestAuthRedirect(t *testing.T) {
+	got := map[string]string{}
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			got[name] = r.Header.Get("Authorization")
+			if to := r.URL.Query().Get("to"); to != "" {
+				http.Redirect(w, r, to, http.StatusFound)
+				return
+			}
+			fmt.Fprintln(w, "package main")
+		}
+	}
+	srv := httptest.NewServer(handler("srv"))
+	defer srv.Close()
+	other := httptest.NewServer(handler("other"))
+	defer other.Close()
+	tls := httptest.NewTLSServer(handler("tls"))
+	defer tls.Close()
+	host := func(s *httptest.Server) string { return s.Listener.Addr().String() }
+	overrides := map[string]string{"docs.example": host(srv), "api.docs.example": host(other)}
+
+	tc := []struct {
+		name string
+		opts []Option
+		url  string
+		auth map[string]string
+		err  string
+	}{
+		{
+			name: "other port",
+			opts: []Option{WithAuth(host(srv), "Bearer", "secret"), WithInsecureAuth(true)},
+			url:  srv.URL + "/code.go?to=" + other.URL + "/code.go",
+			auth: map[string]string{"srv": "Bearer secret", "other": ""},
+		},
+		{
+			name: "plain http",
+			opts: []Option{WithAuth("127.0.0.1", "Bearer", "secret")},
+			url:  tls.URL + "/code.go?to=" + srv.URL + "/code.go",
+			err:  "refusing to send credentials for " + host(srv) + " over http",
+		},
+		{
+			name: "subdomain",
+			opts: []Option{WithAuth("docs.example", "Bearer", "secret"), WithInsecureAuth(true), WithHostOverride(overrides)},
+			url:  "http://docs.example/code.go?to=http://api.docs.example/code.go",
+			auth: map[string]string{"srv": "Bearer secret", "other": ""},
+		},
+		{
+			name: "registered host",
+			opts: []Option{WithAuth(host(srv), "Bearer", "secret"), WithAuth(host(other), "Basic", "dXNlcjpwYXNz"), WithInsecureAuth(true)},
+			url:  srv.URL + "/code.go?to=" + other.URL + "/code.go",
+			auth: map[string]string{"srv": "Bearer secret", "other": "Basic dXNlcjpwYXNz"},
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			got = map[string]string{}
+			f := newEmbedder(tt.opts...).Fetcher.(fetcher)
+			if f.client.Transport == nil {
+				f.client.Transport = tls.Client().Transport
+			}
+			_, err := f.Fetch("", tt.url)
+			if tt.err != "" {
+				if err == nil || !strings.HasSuffix(err.Error(), tt.err) {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				if _, ok := got["srv"]; ok {
+					t.Errorf("case [%s]: expected no request to %s", tt.name, srv.URL)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.auth) {
+				t.Errorf("case [%s]: expected Authorization %q; got %q", tt.name, tt.auth, got)
+			}
+		})
+	}
+}
+
+func TestBackslashPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "embedmd-paths")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "sub", "pkg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", "pkg", "code.go"), []byte("package pkg\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"sub/pkg/code.go", `sub\pkg\code.go`, `sub\pkg/code.go`} {
+		b, err := fetcher{}.Fetch(dir, path)
+		if err != nil {
+			t.Errorf("fetching %s: %v", path, err)
+			continue
+		}
+		if string(b) != "package pkg\n" {
+			t.Errorf("fetching %s: expected %q; got %q", path, "package pkg\n", b)
+		}
+	}
+}
+
+type fakeBlobFetcher map[string]string
+
+func (f fakeBlobFetcher) FetchBlob(bucket, key string) ([]byte, error) {
+	s, ok := f[bucket+"/"+key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return []byte(s), nil
+}
+
+func TestBlobFetcher(t *testing.T) {
+	blobs := fakeBlobFetcher{"docs/src/code.go": "package main\n"}
+	tc := []struct {
+		name string
+		opts []Option
+		path string
+		out  string
+		err  string
+	}{
+		{name: "registered scheme", opts: []Option{WithBlobFetcher("s3", blobs)}, path: "s3://docs/src/code.go", out: "package main\n"},
+		{name: "upper case scheme", opts: []Option{WithBlobFetcher("S3", blobs)}, path: "S3://docs/src/code.go", out: "package main\n"},
+		{name: "unknown scheme", opts: []Option{WithBlobFetcher("s3", blobs)}, path: "gs://docs/src/code.go", err: "no fetcher registered for scheme gs"},
+		{name: "no key", opts: []Option{WithBlobFetcher("s3", blobs)}, path: "s3://docs", err: "s3://docs should have the form s3://bucket/key"},
+		{name: "not registered", path: "s3://docs/src/code.go", err: "no fetcher registered for scheme s3"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := newEmbedder(tt.opts...).Fetch("", tt.path)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.out {
+				t.Errorf("case [%s]: expected %q; got %q", tt.name, tt.out, b)
+			}
+		})
+	}
+}
+
+func TestDataURI(t *testing.T) {
+	tc := []struct {
+		name string
+		uri  string
+		out  string
+		err  string
+	}{
+		{name: "base64", uri: "data:text/plain;base64,cGFja2FnZSBtYWluCg==", out: "package main\n"},
+		{name: "percent encoded", uri: "data:text/plain,package%20main%0A", out: "package main\n"},
+		{name: "no media type", uri: "data:,hello", out: "hello"},
+		{name: "bad base64", uri: "data:;base64,!!!", err: "bad base64 data URI: illegal base64 data at input byte 0"},
+		{name: "missing comma", uri: "data:text/plain", err: "missing comma in data URI"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := fetcher{}.Fetch("", tt.uri)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.out {
+				t.Errorf("case [%s]: expected %q; got %q", tt.name, tt.out, b)
+			}
+		})
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("p"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	start := time.Now()
+	_, err := newEmbedder(WithReadTimeout(50*time.Millisecond)).Fetch("", srv.URL+"/code.go")
+	if want := "no data received for 50ms"; err == nil || err.Error() != want {
+		t.Errorf("expected error %q; got %v", want, err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expected the read to time out quickly; took %v", d)
+	}
+}
+
+func TestReadTimeoutSlowServer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, c := range "package main\n" {
+			fmt.Fprint(w, string(c))
+			w.(http.Flusher).Flush()
+			time.Sleep(5 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	b, err := newEmbedder(WithReadTimeout(time.Second)).Fetch("", srv.URL+"/code.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "package main\n" {
+		t.Errorf("expected %q; got %q", "package main\n", b)
+	}
+}
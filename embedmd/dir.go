@@ -0,0 +1,157 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// WithFileConcurrency sets the number of files processed at the same time by
+// ProcessDir, 1 by default.
+func WithFileConcurrency(n int) Option {
+	return Option{func(e *embedder) { e.fileConcurrency = n }}
+}
+
+// A FileError is an error found by ProcessDir while processing a file.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string { return fmt.Sprintf("%s: %v", e.Path, e.Err) }
+
+// FileErrors is returned by ProcessDir when processing some of the files
+// failed, containing their errors sorted by path.
+type FileErrors []*FileError
+
+func (e FileErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// ProcessDir processes, like Process, every markdown file found in dir and its
+// subdirectories, and rewrites those whose content changes. Paths in commands
+// are relative to the file containing them. The files share a caching Fetcher
+// and the budget given with WithTotalFetchBudget, and are processed
+// concurrently if WithFileConcurrency is given.
+//
+// Every file is replaced atomically with its new content. Files failing to
+// process are left unchanged, and their errors are returned as FileErrors.
+func ProcessDir(dir string, opts ...Option) error {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && isMarkdown(path) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	e := newEmbedder(opts...)
+	f, fetched := NewCachingFetcher(e.Fetcher), e.fetched
+	opts = append(opts[:len(opts):len(opts)], WithFetcher(f), Option{func(e *embedder) { e.fetched = fetched }})
+	workers := e.fileConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs FileErrors
+	)
+	todo := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range todo {
+				if err := processFile(path, opts); err != nil {
+					mu.Lock()
+					errs = append(errs, &FileError{path, err})
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, path := range paths {
+		todo <- path
+	}
+	close(todo)
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+	return errs
+}
+
+// processFile processes the markdown file at path, replacing it if its content
+// changes.
+func processFile(path string, opts []Option) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	opts = append(opts[:len(opts):len(opts)], WithBaseDir(filepath.Dir(path)), WithIncludeGuard(path))
+	if err := Process(&out, bytes.NewReader(b), opts...); err != nil {
+		return err
+	}
+	if bytes.Equal(b, out.Bytes()) {
+		return nil
+	}
+	return writeFileAtomic(path, out.Bytes())
+}
+
+// writeFileAtomic replaces the file at path with the given content, keeping its
+// permissions, by renaming a temporary file written next to it.
+func writeFileAtomic(path string, b []byte) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), fi.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
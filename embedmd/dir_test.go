@@ -0,0 +1,126 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProcessDir(t *testing.T) {
+	var mu sync.Mutex
+	active, max := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		if active > max {
+			max = active
+		}
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		fmt.Fprintf(w, "// %s\n", r.URL.Path)
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "embedmd-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := []string{"a.md", "b.md", "docs/c.md", "docs/d.md"}
+	for _, name := range names {
+		write(name, fmt.Sprintf("[embedmd]:# (%s/%s.go)\n", srv.URL, filepath.Base(name)))
+	}
+	write("docs/broken.md", "[embedmd]:# (missing.go)\n")
+	write("bad.md", "[embedmd]:# (code.go /unbalanced)\n")
+	write("notes.txt", "[embedmd]:# (missing.go)\n")
+
+	err = ProcessDir(dir, WithFileConcurrency(4))
+	want := filepath.Join(dir, "bad.md") + ": 1: unbalanced /\n" +
+		filepath.Join(dir, "docs", "broken.md") + ": 1: could not read missing.go: open " + filepath.Join(dir, "docs", "missing.go") + ": no such file or directory"
+	if err == nil || err.Error() != want {
+		t.Errorf("expected errors:\n%s\ngot:\n%v", want, err)
+	}
+	if max < 2 {
+		t.Errorf("expected files to be processed concurrently; got at most %d simultaneous requests", max)
+	}
+
+	for _, name := range names {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		base := filepath.Base(name)
+		want := fmt.Sprintf("[embedmd]:# (%s/%s.go)\n```go\n// /%s.go\n```\n", srv.URL, base, base)
+		if string(b) != want {
+			t.Errorf("expected %s to be %q; got %q", name, want, b)
+		}
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "docs", "broken.md")); string(b) != "[embedmd]:# (missing.go)\n" {
+		t.Errorf("expected failing files to be left unchanged; got %q", b)
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "docs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range entries {
+		if strings.HasSuffix(fi.Name(), ".tmp") {
+			t.Errorf("expected no temporary file to be left; found %s", fi.Name())
+		}
+	}
+}
+
+func TestProcessDirBudget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "embedmd-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a", "b", "c"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name+".go"), []byte("package "+name+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name+".md"), []byte("[embedmd]:# ("+name+".go)\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// every file fetches 10 bytes, so only two of them fit in the budget.
+	err = ProcessDir(dir, WithTotalFetchBudget(25))
+	if err == nil || !strings.Contains(err.Error(), "total fetch budget of 25 bytes exceeded") {
+		t.Fatalf("expected the budget to be shared by the files; got %v", err)
+	}
+	if n := strings.Count(err.Error(), "\n") + 1; n != 1 {
+		t.Errorf("expected a single file to exceed the budget; got %d errors", n)
+	}
+}
@@ -23,6 +23,9 @@
 // system (using always forward slashes as directory separator) or
 // a url starting with http:// or https://.
 // If the pathOrURL is a url the tool will fetch the content in that url.
+// Paths of the form mod:module@version/file are read from the Go module cache,
+// see ModuleFetcher.
+// Data URIs, such as data:text/plain;base64,SGVsbG8K, are decoded.
 // The embedded content starts at the first line that matches /start regexp/
 // and finishes at the first line matching /end regexp/.
 //
@@ -31,6 +34,11 @@
 //
 //     [embedmd]:# (pathOrURL language /regexp/)
 //
+// To embed the lines of a later match of the regular expression, add its
+// index after it:
+//
+//     [embedmd]:# (pathOrURL language /regexp/[2])
+//
 // To embed the whole line matching a regular expression you can use:
 //
 //     [embedmd]:# (pathOrURL language /.*regexp.*\n/)
@@ -39,9 +47,158 @@
 //
 //     [embedmd]:# (pathOrURL language /start regexp/ $)
 //
-// Finally you can embed a whole file by omitting both regular expressions:
+// Finally you can embed a whole file by omitting both regular expressions,
+// giving the language with lang= as a lone argument after the path is the
+// name of a sample:
+//
+//     [embedmd]:# (pathOrURL lang=language)
+//
+// To embed a sample delimited by lines containing START name and END name,
+// add the name of the sample after the path, or after the language:
+//
+//     [embedmd]:# (pathOrURL name)
+//     [embedmd]:# (pathOrURL language name)
+//
+// Several samples, named separated by commas, are embedded one after the
+// other, separated by a blank line or the separator given with
+// WithSampleSeparator:
+//
+//     [embedmd]:# (pathOrURL language setup,teardown)
+//
+// Regions of plain text files delimited by sentinel lines are embedded with the
+// begin and end arguments, matching whole lines literally. The sentinel lines
+// are not embedded:
+//
+//     [embedmd]:# (notes.txt text begin="--- begin ---" end="--- end ---")
+//
+// The brace keyword embeds from the line matching /start regexp/ up to the
+// line closing the first brace opened after it, ignoring braces in strings
+// and comments. This is useful to embed a function in C-family languages:
+//
+//     [embedmd]:# (pathOrURL language /start regexp/ brace)
+//
+// Similarly, the indentblock keyword embeds the line matching the regexp and
+// the lines indented more than it, as for a key and its values in YAML or a
+// function in Python:
+//
+//     [embedmd]:# (config.yaml yaml /^  database:/ indentblock)
+//
+// From Go files, a function or method and its doc comment can be embedded with
+// the func argument, naming methods after their receiver type. Adding the
+// signature keyword omits the body of the function:
+//
+//     [embedmd]:# (pathOrURL go func=Type.Method signature)
+//
+// The exported keyword embeds an overview of the API of a Go file: its exported
+// declarations, with the bodies of functions elided:
+//
+//     [embedmd]:# (pathOrURL go exported)
+//
+// The pkgdoc keyword embeds the package doc comment of a Go file as markdown,
+// without comment markers nor code fence, delimited by comments like raw
+// content:
+//
+//     [embedmd]:# (doc.go pkgdoc)
+//
+// In JavaScript and TypeScript files, func names a function declaration, a
+// const, let or var assigned an arrow function with a block body, or a method:
+//
+//     [embedmd]:# (server.ts func=handler)
+//
+// From HTML files, the first element matching a simple CSS selector, made of a
+// tag name, an #id, and .classes, can be embedded with the css argument:
+//
+//     [embedmd]:# (page.html html css=div.example)
+//
+// From protocol buffer definitions, a message can be embedded by name, using
+// Outer.Inner for nested messages:
+//
+//     [embedmd]:# (api.proto proto message=User)
+//
+// From Jupyter notebooks, the source of a code cell can be embedded by number,
+// starting at 1:
+//
+//     [embedmd]:# (notebook.ipynb python cell=3)
+//
+// From go.mod files, the gomod keyword embeds, without code fence, the Go
+// version of the module or, with the require argument, the version of one of
+// its dependencies:
+//
+//     [embedmd]:# (go.mod gomod require=golang.org/x/mod)
+//
+// CSV files can be embedded as a table, using the first row as header:
+//
+//     [embedmd]:# (data.csv table)
+//
+// The exported fields of a Go struct, with their type, tag and doc comment,
+// can be embedded as a table with the structdoc argument:
 //
-//     [embedmd]:# (pathOrURL language)
+//     [embedmd]:# (config.go structdoc=Config)
+//
+// The sort and uniq keywords sort the embedded lines and remove the duplicated
+// ones, after the transformers given with WithTransformers:
+//
+//     [embedmd]:# (allowed.txt lang=text sort uniq)
+//
+// The highlight argument adds the lines to highlight, numbered from 1 in the
+// embedded content, to the info string of the code fence, as in ```go {1,3-5}
+// for renderers supporting it:
+//
+//     [embedmd]:# (pathOrURL go /func main/ $ highlight=1,3-5)
+//
+// The fragment of the URL of a markdown document selects the section under the
+// heading with that anchor, up to the next heading of the same level or higher:
+//
+//     [embedmd]:# (https://example.com/README.md#getting-started lang=markdown)
+//
+// For local files tracked by git, the blame keyword annotates every embedded
+// line with the abbreviated hash of the commit that last changed it. As it
+// runs git, it must be enabled with WithGitBlame:
+//
+//     [embedmd]:# (pathOrURL language name blame)
+//
+// Similarly, once enabled with WithGitDiff, the since argument embeds only the
+// lines added to a local file since the given git revision:
+//
+//     [embedmd]:# (CHANGELOG.md markdown since=v1.2.0)
+//
+// The headers keyword embeds the status line and headers of the response to a
+// HEAD request to a URL, as an http block. It must be enabled with
+// WithAllowHeaderEmbed:
+//
+//     [embedmd]:# (https://example.com/api headers)
+//
+// The head and tail arguments keep only the first or last lines of the
+// embedded content, marking the omitted lines with "...":
+//
+//     [embedmd]:# (pathOrURL lang=language head=5)
+//
+// The context argument adds the given number of lines around an embedded
+// sample or regular expression match, separated from the rest of the file
+// with "...":
+//
+//     [embedmd]:# (pathOrURL language name context=2)
+//
+// The cols argument keeps only the given columns of every line, counting from
+// 1. The end of the range can be omitted to keep the lines until their end:
+//
+//     [embedmd]:# (data.txt lang=text cols=10:40)
+//
+// Every embedded line can be prefixed with some text, given in double quotes:
+//
+//     [embedmd]:# (pathOrURL lang=language prefix="$ ")
+//
+// Using raw as the language embeds the content as is, without surrounding it
+// with a code fence. The commands found in raw content from local files are
+// run too, relative to the directory of the file, and removed from the
+// embedded content. This is useful to share a piece of markdown across
+// documents. Raw content is delimited by comments, so it is replaced when
+// processing the document again:
+//
+//     [embedmd]:# (pathOrURL raw)
+//     <!-- embedmd:raw -->
+//     ...
+//     <!-- embedmd:endraw -->
 //
 // You can ommit the language in any of the previous commands, and the extension
 // of the file will be used for the snippet syntax highlighting. Note that while
@@ -50,26 +207,344 @@
 //
 //     [embedmd]:# (file.ext)
 //
+// For files without extension, the language is guessed from the interpreter
+// of their shebang line, if any, such as #!/bin/bash.
+//
 package embedmd
 
 import (
-	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
+	"html"
 	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
 )
 
 // Process reads markdown from the given io.Reader searching for an embedmd
 // command. When a command is found, it is executed and the output is written
 // into the given io.Writer with the rest of standard markdown.
 func Process(out io.Writer, in io.Reader, opts ...Option) error {
-	e := embedder{Fetcher: fetcher{}}
+	e := newEmbedder(opts...)
+	if e.progress != nil || e.timestampFooter || e.warnTypos {
+		b, err := ioutil.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		if e.warnTypos {
+			for _, t := range findTypos(b) {
+				e.logf("%d: %q looks like a mistyped embedmd command, did you mean %q?", t.line, t.text, t.fix)
+			}
+		}
+		if e.timestampFooter {
+			b = removeFooter(b)
+		}
+		e.total = countCommands(b)
+		in = bytes.NewReader(b)
+	}
+	if err := e.process(out, in); err != nil {
+		return err
+	}
+	if e.timestampFooter {
+		fmt.Fprintf(out, "%s%s -->\n", footerPrefix, e.now().UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Update runs the embedmd commands found in the given markdown like Process,
+// replacing only the content they generate. The rest of the document is kept
+// byte for byte, including its line endings.
+func Update(content []byte, opts ...Option) ([]byte, error) {
+	var out bytes.Buffer
+	opts = append(opts[:len(opts):len(opts)], Option{func(e *embedder) { e.exact = true }})
+	if err := Process(&out, bytes.NewReader(content), opts...); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Diff runs the embedmd commands found in the given markdown like Process, but
+// writes to out a unified diff of the changes Process would make instead of the
+// output. It reports whether there are any changes. The files given with out=
+// are not written. The diff goes from a/path to b/path, with the path of the
+// document given with WithIncludeGuard, or from original to processed if there
+// is none.
+func Diff(out io.Writer, in io.Reader, opts ...Option) (bool, error) {
+	from, to := "original", "processed"
+	opts = append(opts[:len(opts):len(opts)], Option{func(e *embedder) {
+		e.dryRun = true
+		if len(e.includes) > 0 {
+			name := filepath.ToSlash(e.includes[0].name)
+			from, to = "a/"+name, "b/"+name
+		}
+	}})
+	var processed, read bytes.Buffer
+	if err := Process(&processed, io.TeeReader(in, &read), opts...); err != nil {
+		return false, err
+	}
+	d, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(read.String()),
+		B:        difflib.SplitLines(processed.String()),
+		FromFile: from,
+		ToFile:   to,
+		Context:  3,
+	})
+	if err != nil || len(d) == 0 {
+		return false, err
+	}
+	_, err = io.WriteString(out, d)
+	return true, err
+}
+
+// WithIncludeGuard gives the path of the processed document, so that embedding
+// it from itself, directly or through other documents, is detected as a cycle.
+func WithIncludeGuard(path string) Option {
+	return Option{func(e *embedder) {
+		if abs, err := filepath.Abs(path); err == nil {
+			e.includes = []include{{abs, path}}
+		}
+	}}
+}
+
+// defaultMaxDepth is the number of nested documents that can be embedded
+// unless WithMaxDepth is given.
+const defaultMaxDepth = 8
+
+// WithMaxDepth limits to n the number of documents, embedded raw, that can be
+// nested in one another, 8 by default. If n is zero, there is no limit.
+func WithMaxDepth(n int) Option {
+	return Option{func(e *embedder) { e.maxDepth = n }}
+}
+
+// An include is a markdown document being processed.
+type include struct {
+	path string // absolute path, if known.
+	name string // path as written in the command.
+}
+
+// processRaw runs the commands found in the raw content b of the local file
+// named by path, resolving their paths relative to it. It is an error if the
+// file is already being processed.
+func (e *embedder) processRaw(path string, b []byte) ([]byte, error) {
+	if !isLocalPath(path) {
+		return b, nil
+	}
+	if !bytes.Contains(b, []byte(commandPrefix)) {
+		return b, nil
+	}
+	abs, err := filepath.Abs(localPath(e.baseDir, path))
+	if err != nil {
+		return nil, err
+	}
+	for i, inc := range e.includes {
+		if inc.path != abs {
+			continue
+		}
+		var names []string
+		for _, inc := range e.includes[i:] {
+			names = append(names, inc.name)
+		}
+		return nil, fmt.Errorf("embed cycle detected: %s -> %s", strings.Join(names, " -> "), path)
+	}
+
+	if e.maxDepth > 0 && e.depth >= e.maxDepth {
+		return nil, fmt.Errorf("could not embed %s: maximum embedding depth of %d exceeded", path, e.maxDepth)
+	}
+
+	baseDir, includes, depth := e.baseDir, e.includes, e.depth
+	defer func() { e.baseDir, e.includes, e.depth = baseDir, includes, depth }()
+	e.baseDir = filepath.Dir(abs)
+	e.includes = append(includes[:len(includes):len(includes)], include{abs, path})
+	e.depth++
+
+	var out bytes.Buffer
+	if err := process(&out, bytes.NewReader(b), func(w io.Writer, cmd *command) error {
+		return e.embed(w, cmd)
+	}, true, e.maxLineLength, nil); err != nil {
+		return nil, fmt.Errorf("could not process %s: %v", path, err)
+	}
+	return stripDirectives(out.Bytes()), nil
+}
+
+// The comments delimiting the content embedded raw.
+const (
+	rawBegin = "<!-- embedmd:raw -->"
+	rawEnd   = "<!-- embedmd:endraw -->"
+)
+
+// stripDirectives removes from the processed markdown b the lines holding
+// commands and the comments delimiting raw content, outside of code fences,
+// so they are not run again as commands of the document embedding b.
+func stripDirectives(b []byte) []byte {
+	var out []byte
+	fenced := false
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n') + 1
+		if i == 0 {
+			i = len(b)
+		}
+		line := b[:i]
+		b = b[i:]
+		switch t := strings.TrimRight(string(line), "\r\n"); {
+		case strings.HasPrefix(t, "```"):
+			fenced = !fenced
+		case fenced:
+		case strings.HasPrefix(t, commandPrefix), t == rawBegin, t == rawEnd:
+			continue
+		}
+		out = append(out, line...)
+	}
+	return out
+}
+
+// A Document is a markdown document to be processed by MultiProcess.
+type Document struct {
+	In  io.Reader
+	Out io.Writer
+}
+
+// MultiProcess processes each of the given documents like Process, sharing a
+// caching Fetcher so that content referenced by several documents is fetched
+// only once. It stops at the first document that fails.
+func MultiProcess(docs []Document, opts ...Option) error {
+	f := NewCachingFetcher(newEmbedder(opts...).Fetcher)
+	opts = append(opts[:len(opts):len(opts)], WithFetcher(f))
+	for i, d := range docs {
+		if err := Process(d.Out, d.In, opts...); err != nil {
+			return fmt.Errorf("document %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// footerPrefix starts the comment added by WithTimestampFooter.
+const footerPrefix = "<!-- embedmd: generated at "
+
+// removeFooter removes the timestamp footer added by a previous run, which is
+// the last line of the document.
+func removeFooter(b []byte) []byte {
+	i := bytes.LastIndexByte(bytes.TrimSuffix(b, []byte("\n")), '\n') + 1
+	if bytes.HasPrefix(b[i:], []byte(footerPrefix)) {
+		return b[:i]
+	}
+	return b
+}
+
+func (e *embedder) process(out io.Writer, in io.Reader) error {
+	run := e.runCommand
+	if e.dedupBlocks {
+		blocks := &adjacentBlocks{w: out}
+		out, run = blocks, blocks.dedup(e.runCommand)
+	}
+	if !e.collectErrors {
+		return process(out, in, run, e.exact, e.maxLineLength, nil)
+	}
+
+	var errs Errors
+	err := process(out, in, func(w io.Writer, cmd *command) error {
+		if e.tooManyCommands() {
+			return run(w, cmd)
+		}
+		if err := run(w, cmd); err != nil {
+			errs = append(errs, &LineError{cmd.line, err})
+		}
+		return nil
+	}, e.exact, e.maxLineLength, func(err *LineError) {
+		errs = append(errs, err)
+	})
+	if err, ok := err.(*LineError); ok {
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs.sorted()
+}
+
+// A LineError is an error found while processing the given line of a
+// markdown document.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string { return fmt.Sprintf("%d: %v", e.Line, e.Err) }
+
+// Errors is returned by Process when WithCollectErrors is used, containing
+// the errors of every failed command sorted by line.
+type Errors []*LineError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// sorted returns the errors sorted by line, removing duplicates, so the result
+// doesn't depend on the order in which they were found.
+func (e Errors) sorted() Errors {
+	sorted := append(Errors(nil), e...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Line < sorted[j].Line })
+
+	var res Errors
+	seen := make(map[string]bool)
+	for _, err := range sorted {
+		if msg := err.Error(); !seen[msg] {
+			seen[msg] = true
+			res = append(res, err)
+		}
+	}
+	return res
+}
+
+// newEmbedder returns an embedder configured with the given options.
+func newEmbedder(opts ...Option) *embedder {
+	e := &embedder{
+		ensureNewline:   true,
+		now:             time.Now,
+		redactedHeaders: redactedHeaders,
+		maxDepth:        defaultMaxDepth,
+		maxRedirects:    defaultMaxRedirects,
+		playgroundURL:   defaultPlaygroundURL,
+		fetched:         new(int64),
+	}
 	for _, opt := range opts {
-		opt.f(&e)
+		opt.f(e)
+	}
+	if e.Fetcher == nil {
+		e.Fetcher = e.defaultFetcher()
 	}
-	return process(out, in, e.runCommand)
+	if e.reproducible {
+		e.timestampFooter, e.sourceTimestamp, e.exact = false, false, false
+		e.newline = "lf"
+	}
+	return e
+}
+
+// countCommands returns the number of commands found in the given markdown.
+// Parsing errors are ignored, they will be reported while processing.
+func countCommands(b []byte) int {
+	n := 0
+	process(ioutil.Discard, bytes.NewReader(b), func(io.Writer, *command) error {
+		n++
+		return nil
+	}, false, 0, func(*LineError) {})
+	return n
 }
 
 // An Option provides a way to adapt the Process function to your needs.
@@ -87,74 +562,1591 @@ func WithFetcher(c Fetcher) Option {
 	return Option{func(e *embedder) { e.Fetcher = c }}
 }
 
+// WithTotalFetchBudget limits the total number of bytes fetched across all
+// the commands in a document, or in all the documents processed by ProcessDir.
+// Processing fails as soon as the budget is exceeded.
+func WithTotalFetchBudget(n int64) Option {
+	return Option{func(e *embedder) { e.fetchBudget = n }}
+}
+
+// WithEnsureTrailingNewline controls whether a newline is appended to embedded
+// content that doesn't end with one, which is the default. When disabled such
+// content is kept as is, so the file written by the out argument doesn't end
+// with a newline either. The closing fence, and the markdown following raw
+// content, always start on their own line.
+func WithEnsureTrailingNewline(ensure bool) Option {
+	return Option{func(e *embedder) { e.ensureNewline = ensure }}
+}
+
+// WithProgress registers a function called every time a command has been
+// executed, with the number of commands executed so far and the total number
+// of commands in the document.
+func WithProgress(f func(done, total int)) Option {
+	return Option{func(e *embedder) { e.progress = f }}
+}
+
+// WithCaseInsensitiveMarkers makes the START and END keywords of sample
+// markers match regardless of their case. Sample names are still case
+// sensitive.
+func WithCaseInsensitiveMarkers(insensitive bool) Option {
+	return Option{func(e *embedder) { e.markers.caseInsensitive = insensitive }}
+}
+
+// WithLinePrefix adds the given prefix to every embedded line, which can be
+// useful for shell sessions or block quotes. Blank lines are not prefixed
+// unless WithPrefixBlankLines is also used. The prefix can also be set for a
+// single command with prefix="text".
+func WithLinePrefix(prefix string) Option {
+	return Option{func(e *embedder) { e.linePrefix = prefix }}
+}
+
+// WithPrefixBlankLines indicates whether blank lines should also receive the
+// line prefix.
+func WithPrefixBlankLines(prefix bool) Option {
+	return Option{func(e *embedder) { e.prefixBlankLines = prefix }}
+}
+
+// A MissingFilePolicy indicates how to handle commands referring to files that
+// do not exist.
+type MissingFilePolicy int
+
+const (
+	// MissingFileError makes Process fail, this is the default.
+	MissingFileError MissingFilePolicy = iota
+	// MissingFileWarn embeds a <!-- missing: path --> comment instead of the
+	// content and continues processing.
+	MissingFileWarn
+	// MissingFileSkip embeds nothing and continues processing.
+	MissingFileSkip
+)
+
+// missingPrefix starts the comment embedded for missing files.
+const missingPrefix = "<!-- missing: "
+
+// mtimePrefix starts the comment added by WithSourceTimestamp.
+const mtimePrefix = "<!-- source mtime: "
+
+// WithSourceTimestamp adds a comment with the modification time of the
+// embedded file before its content, when the Fetcher is a ModTimeFetcher
+// and the time is known.
+func WithSourceTimestamp(timestamp bool) Option {
+	return Option{func(e *embedder) { e.sourceTimestamp = timestamp }}
+}
+
+// WithMissingFilePolicy sets how to handle commands referring to files that
+// do not exist.
+func WithMissingFilePolicy(p MissingFilePolicy) Option {
+	return Option{func(e *embedder) { e.missingFile = p }}
+}
+
+// WithCollectErrors makes Process continue after a command fails, or cannot be
+// parsed, so all the failures in a document are reported at once as Errors.
+// Nothing is embedded for the failed commands.
+func WithCollectErrors(collect bool) Option {
+	return Option{func(e *embedder) { e.collectErrors = collect }}
+}
+
+// WithReindent indents every non empty line by n spaces, once the indentation
+// common to all the lines has been removed.
+func WithReindent(n int) Option {
+	return Option{func(e *embedder) { e.reindent = n }}
+}
+
+// WithClock sets the function used to read the current time, which defaults
+// to time.Now, including to expire the content of a CachingFetcher given with
+// WithFetcher. This is mostly useful to obtain reproducible output in tests.
+func WithClock(now func() time.Time) Option {
+	return Option{func(e *embedder) { e.now = now }}
+}
+
+// WithTimestampFooter adds a comment with the time of generation at the end of
+// the document, replacing the one added by previous runs.
+func WithTimestampFooter(footer bool) Option {
+	return Option{func(e *embedder) { e.timestampFooter = footer }}
+}
+
+// WithMaxDirectives limits the number of embedmd commands in a document.
+// Process fails when it finds the command over the limit.
+func WithMaxDirectives(n int) Option {
+	return Option{func(e *embedder) { e.maxCommands = n }}
+}
+
+// WithVerifyGo makes Process fail when the embedded Go code looks like a
+// complete file, because it contains a package clause, and it can't be parsed.
+func WithVerifyGo(verify bool) Option {
+	return Option{func(e *embedder) { e.verifyGo = verify }}
+}
+
+// WithMaxConnsPerHost limits the number of connections opened to each host by
+// the default Fetcher.
+func WithMaxConnsPerHost(n int) Option {
+	return Option{func(e *embedder) { e.maxConnsPerHost = n }}
+}
+
+// WithProxy sets the function returning the proxy used by the default Fetcher
+// for each request, as http.Transport.Proxy does. It can choose a proxy per
+// host, or return a nil URL to fetch directly. Without it, the proxy is read
+// from the environment by http.ProxyFromEnvironment.
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) Option {
+	return Option{func(e *embedder) { e.proxy = proxy }}
+}
+
+// WithHostOverride makes the default Fetcher connect to other addresses than
+// the ones hosts resolve to, as for testing against a staging server without
+// editing the commands. The map gives the host:port to connect to for a host,
+// such as example.com, or a host and port, such as example.com:443. Requests
+// keep the URL and Host header of the commands.
+func WithHostOverride(overrides map[string]string) Option {
+	return Option{func(e *embedder) { e.hostOverride = overrides }}
+}
+
+// defaultMaxRedirects is the number of redirects followed by the default
+// Fetcher unless WithMaxRedirects is given.
+const defaultMaxRedirects = 10
+
+// WithMaxRedirects limits to n the number of redirects followed by the default
+// Fetcher for each URL, failing when a server redirects more. Zero follows no
+// redirects at all.
+func WithMaxRedirects(n int) Option {
+	return Option{func(e *embedder) { e.maxRedirects = n }}
+}
+
+// WithValidateURL sets a function called by the default Fetcher with every
+// URL before fetching it, including the URLs it is redirected to. It returns
+// the URL to fetch, possibly rewritten, or an error to reject it.
+func WithValidateURL(validate func(u *url.URL) (*url.URL, error)) Option {
+	return Option{func(e *embedder) { e.validateURL = validate }}
+}
+
+// WithAcceptHeader sets the Accept header of the requests made by the default
+// Fetcher, such as text/plain for servers returning HTML unless asked for the
+// source.
+func WithAcceptHeader(accept string) Option {
+	return Option{func(e *embedder) { e.accept = accept }}
+}
+
+// The comments added by WithSourceMap around the content embedded for each
+// command.
+const (
+	sourceMapBegin = "<!-- embedmd:begin "
+	sourceMapEnd   = "<!-- embedmd:end -->"
+)
+
+// WithSourceMap surrounds the content embedded for each command with comments
+// recording the command, such as:
+//
+//	<!-- embedmd:begin hello.go go sample -->
+//	...
+//	<!-- embedmd:end -->
+//
+// Everything between the comments is replaced when processing the document
+// again.
+func WithSourceMap(sourceMap bool) Option {
+	return Option{func(e *embedder) { e.sourceMap = sourceMap }}
+}
+
+// WithReadTimeout makes the default Fetcher fail when no data is received from
+// a server for the given duration while reading a response. This is unlike a
+// timeout for the whole request, which fails for large slow downloads.
+func WithReadTimeout(d time.Duration) Option {
+	return Option{func(e *embedder) { e.readTimeout = d }}
+}
+
+// The HTML tags surrounding collapsible content.
+const (
+	detailsOpen  = "<details><summary>"
+	detailsClose = "</details>"
+)
+
+// WithCollapsible puts the content embedded for each command in an HTML
+// details element with the given summary, so that it is collapsed until the
+// reader clicks on the summary. The collapse argument of a command sets its
+// own summary:
+//
+//	[embedmd]:# (hello.go collapse="Full example")
+func WithCollapsible(summary string) Option {
+	return Option{func(e *embedder) { e.summary = summary }}
+}
+
+// WithAuth makes the default Fetcher send an Authorization header with the
+// given scheme, such as Basic or Bearer, and credential to the given host.
+// The host can include a port. Credentials are only sent over https, unless
+// WithInsecureAuth is given, and redirects to other hosts do not get them.
+func WithAuth(host, scheme, credential string) Option {
+	return Option{func(e *embedder) {
+		if e.auth == nil {
+			e.auth = make(map[string]string)
+		}
+		e.auth[host] = scheme + " " + credential
+	}}
+}
+
+// WithBlobFetcher makes the default Fetcher use f for the URLs with the given
+// scheme, such as s3 for s3://bucket/key. Without it, fetching URLs with
+// schemes other than http and https fails.
+func WithBlobFetcher(scheme string, f BlobFetcher) Option {
+	return Option{func(e *embedder) {
+		if e.blobFetchers == nil {
+			e.blobFetchers = make(map[string]BlobFetcher)
+		}
+		e.blobFetchers[strings.ToLower(scheme)] = f
+	}}
+}
+
+// WithNamedSource makes the default Fetcher read the path named:name from r,
+// as in [embedmd]:# (named:buildinfo lang=text), to embed content generated while
+// processing. The reader is read once, when first embedded, and its content
+// reused by the next commands embedding it.
+func WithNamedSource(name string, r io.Reader) Option {
+	// The source is shared by the documents processed with the option.
+	s := &namedSource{r: r}
+	return Option{func(e *embedder) {
+		if e.namedSources == nil {
+			e.namedSources = make(map[string]*namedSource)
+		}
+		e.namedSources[name] = s
+	}}
+}
+
+// WithInsecureAuth allows the credentials given with WithAuth to be sent over
+// plain http.
+func WithInsecureAuth(allow bool) Option {
+	return Option{func(e *embedder) { e.insecureAuth = allow }}
+}
+
 type embedder struct {
 	Fetcher
-	baseDir string
+	baseDir       string
+	ensureNewline bool
+	markers       markers
+	missingFile   MissingFilePolicy
+	collectErrors bool
+
+	now             func() time.Time
+	timestampFooter bool
+	sourceTimestamp bool
+
+	report *Report
+	exact  bool // whether the text around commands is kept byte for byte.
+	dryRun bool // whether the files given with out= are left untouched.
+
+	includes []include // markdown documents being processed, outermost first.
+	depth    int       // number of documents embedded raw being processed.
+	maxDepth int       // zero means no limit.
+
+	fileConcurrency int // number of files processed at once by ProcessDir.
+
+	linePrefix       string
+	prefixBlankLines bool
+	reindent         int
+	tabWidth         int
+	trimDangling     bool
+	wrapMain         bool
+	showImports      bool
+	transformers     []Transformer
+	verifyGo         bool
+	normalizePolicy  map[string]NormalizeMode
+	extDefaults      map[string]ExtOptions
+	indentIgnore     *regexp.Regexp
+	gitBlame         bool
+	gitDiff          bool
+	requireTracked   bool
+	stripLicense     bool
+	keepMarkers      bool
+	linkedCaption    bool
+	breadcrumb       bool
+	allowEmptyMatch  bool
+	newline          string
+	escapeRaw        bool
+	pathRewrite      func(string) string
+	previewLines     int
+	previewLink      string
+	sampleSeparator  string
+	contentLang      bool
+	playgroundLink   bool
+	dedupBlocks      bool
+	playgroundURL    string
+	replacer         *strings.Replacer
+	headerEmbed      bool
+	redactedHeaders  []string
+	stripComments    bool
+	sourceMap        bool
+	summary          string // of the details element added by WithCollapsible.
+	requireLang      bool
+	reproducible     bool
+	logger           *log.Logger
+	warnTypos        bool
+	binaryFallback   bool
+	fileOutput       bool
+	maxLineLength    int // zero means no limit.
+	indentAnnotation bool
+	blockSummary     bool
+	redactRE         *regexp.Regexp
+	onResolve        func(cmd *Command, size int)
+
+	fetchBudget int64  // zero means no limit.
+	fetched     *int64 // shared by the documents processed by ProcessDir.
+	maxCommands int    // zero means no limit.
+	commands    int
+
+	maxConnsPerHost int
+	auth            map[string]string // Authorization header values by host.
+	insecureAuth    bool
+	blobFetchers    map[string]BlobFetcher // by URL scheme.
+	namedSources    map[string]*namedSource
+	readTimeout     time.Duration
+	proxy           func(*http.Request) (*url.URL, error)
+	hostOverride    map[string]string
+	maxRedirects    int
+	validateURL     func(*url.URL) (*url.URL, error)
+	accept          string
+
+	progress    func(done, total int)
+	done, total int
 }
 
 func (e *embedder) runCommand(w io.Writer, cmd *command) error {
-	b, err := e.Fetch(e.baseDir, cmd.path)
-	if err != nil {
-		return fmt.Errorf("could not read %s: %v", cmd.path, err)
+	if e.tooManyCommands() {
+		return fmt.Errorf("too many commands, the limit is %d", e.maxCommands)
 	}
-	b, err = extract(b, cmd.sample)
-	if err != nil {
-		return fmt.Errorf("could not extract content from %s: %v", cmd.path, err)
+	e.commands++
+	cmd.shownPath = cmd.path
+	if e.pathRewrite != nil {
+		cmd.shownPath = e.pathRewrite(cmd.path)
 	}
-
-	if len(b) > 0 && b[len(b)-1] != '\n' {
-		b = append(b, '\n')
+	// the output is only written once the command succeeds, so that nothing
+	// is embedded for the commands failing with WithCollectErrors.
+	var buf bytes.Buffer
+	var bw io.Writer = &buf
+	switch e.newline {
+	case "", "lf":
+	case "crlf":
+		bw = &crlfWriter{w: bw}
+	default:
+		return fmt.Errorf("unknown newline style %q, want lf or crlf", e.newline)
+	}
+	if e.sourceMap {
+		fmt.Fprintf(bw, "%s%s -->\n", sourceMapBegin, strings.Replace(cmd.args, cmd.path, cmd.shownPath, 1))
+	}
+	if err := e.embed(bw, cmd); err != nil {
+		return err
+	}
+	if e.sourceMap {
+		fmt.Fprintln(bw, sourceMapEnd)
+	}
+	if _, err := buf.WriteTo(w); err != nil {
+		return err
 	}
+	if e.progress != nil {
+		e.done++
+		e.progress(e.done, e.total)
+	}
+	return nil
+}
 
-	fmt.Fprintln(w, "```go")
-	scanner := bufio.NewScanner(bytes.NewBuffer(b))
-	var code []string
-	for scanner.Scan() {
-		t := scanner.Text()
-		if strings.Contains(t, "START") {
-			continue
-		}
-		if strings.Contains(t, "END") {
-			continue
+// fetch fetches the given path, and its modification time if requested with
+// WithSourceTimestamp and known by the Fetcher. A CachingFetcher expires its
+// content according to the clock given with WithClock.
+func (e *embedder) fetch(path string) ([]byte, time.Time, error) {
+	if cf, ok := e.Fetcher.(*CachingFetcher); ok {
+		b, mtime, err := cf.fetchAt(e.now(), e.baseDir, path)
+		if !e.sourceTimestamp {
+			mtime = time.Time{}
 		}
-		code = append(code, scanner.Text())
+		return b, mtime, err
 	}
-	for _, c := range normalize(code) {
-		fmt.Fprintln(w, c)
+	if mf, ok := e.Fetcher.(ModTimeFetcher); ok && e.sourceTimestamp {
+		return mf.FetchModTime(e.baseDir, path)
 	}
-	fmt.Fprintln(w, "```")
-	return nil
+	b, err := e.Fetch(e.baseDir, path)
+	return b, time.Time{}, err
 }
 
-func normalize(s []string) []string {
-	indent := -1
-loop:
-	for i := 0; ; i++ {
-		for _, line := range s {
-			if line == "" {
-				continue
-			}
-			if strings.LastIndex(line, "\t") != i {
-				break loop
-			}
-			indent = i
+// tooManyCommands reports whether the next command to run is over the limit
+// set by WithMaxDirectives.
+func (e *embedder) tooManyCommands() bool {
+	return e.maxCommands > 0 && e.commands >= e.maxCommands
+}
+
+func (e *embedder) embed(w io.Writer, cmd *command) error {
+	var b []byte
+	var mtime time.Time
+	var err error
+	if cmd.headers {
+		b, err = e.fetchHeaders(cmd.path)
+	} else {
+		b, mtime, err = e.fetch(cmd.path)
+	}
+	if errors.Is(err, os.ErrNotExist) && e.missingFile != MissingFileError {
+		if e.missingFile == MissingFileWarn {
+			fmt.Fprintf(w, "%s%s -->\n", missingPrefix, cmd.shownPath)
 		}
+		return nil
 	}
-	if indent == -1 {
-		return s
+	if err != nil {
+		return fmt.Errorf("could not read %s: %v", cmd.path, err)
 	}
-	for i, line := range s {
-		if line == "" {
-			continue
+	if e.requireTracked {
+		if err := e.checkTracked(cmd.path); err != nil {
+			return fmt.Errorf("could not embed %s: %v", cmd.path, err)
 		}
-		s[i] = line[indent+1:]
+	}
+	if fetched := atomic.AddInt64(e.fetched, int64(len(b))); e.fetchBudget > 0 && fetched > e.fetchBudget {
+		return fmt.Errorf("could not read %s: total fetch budget of %d bytes exceeded", cmd.path, e.fetchBudget)
+	}
+	base, section := splitFragment(cmd.path)
+	if section != "" && isMarkdown(base) {
+		var headings []string
+		if b, headings, err = extractMarkdownSection(b, section); err != nil {
+			return fmt.Errorf("could not extract content from %s: %v", cmd.path, err)
+		}
+		if e.breadcrumb {
+			b = append(breadcrumb(headings), b...)
+		}
+	}
+	src := b
+	switch {
+	case cmd.goFunc != "" && isJS(cmd.lang, cmd.path):
+		b, err = jsSymbolExtract(b, cmd.goFunc)
+	case cmd.goFunc != "":
+		b, err = extractGoFunc(b, cmd.goFunc, cmd.signature)
+		if err == nil && e.showImports {
+			var imports []byte
+			if imports, err = goFuncImports(src, cmd.goFunc, cmd.signature); err == nil {
+				b = append(imports, b...)
+			}
+		}
+	case cmd.since != "":
+		b, err = e.addedLines(cmd.path, cmd.since)
+	case cmd.structDoc != "":
+		b, err = extractStructDoc(b, cmd.structDoc)
+	case cmd.exported:
+		b, err = extractExported(b)
+	case cmd.pkgDoc:
+		b, err = extractGoDoc(b)
+	case cmd.css != "":
+		b, err = extractCSS(b, cmd.css)
+	case cmd.message != "":
+		b, err = extractProtoMessage(b, cmd.message)
+	case cmd.cell > 0:
+		b, err = extractNotebookCell(b, cmd.cell)
+	case cmd.gomod:
+		b, err = extractGoModVersion(b, cmd.require)
+	case cmd.brace:
+		b, err = extractBrace(b, cmd.start)
+	case cmd.indent:
+		b, err = extractIndentBlock(b, cmd.start)
+	case cmd.occurrence > 0:
+		b, err = extractNthMatch(b, cmd.start, cmd.occurrence)
+	case cmd.start != "":
+		b, err = extractRegexp(b, cmd.start, cmd.end)
+	case cmd.sample != "":
+		b, err = e.extractSamples(b, cmd.sample)
+	case cmd.begin != "":
+		b, err = extractBetweenLines(b, cmd.begin, cmd.endLine)
+	case e.stripLicense && section == "" && !cmd.headers:
+		b = stripLicenseHeader(b)
+	}
+	if _, ok := err.(noMatchError); ok && e.allowEmptyMatch {
+		e.logf("%d: %s: %v, embedding empty content", cmd.line, cmd.path, err)
+		b, err = nil, nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not extract content from %s: %v", cmd.path, err)
+	}
+	var hashes []string
+	if cmd.blame {
+		if hashes, err = e.blame(cmd.path, src, b); err != nil {
+			return fmt.Errorf("could not blame %s: %v", cmd.path, err)
+		}
+	}
+	var ctx regionContext
+	if cmd.context > 0 {
+		if ctx, err = contextLines(src, b, cmd.context); err != nil {
+			return fmt.Errorf("could not extract context from %s: %v", cmd.path, err)
+		}
+	}
+	if e.replacer != nil {
+		b = []byte(e.replacer.Replace(string(b)))
+	}
+
+	if e.ensureNewline && len(b) > 0 && b[len(b)-1] != '\n' {
+		// b can be a part of src, which must not be overwritten.
+		b = append(b[:len(b):len(b)], '\n')
+	}
+	terminated := len(b) == 0 || b[len(b)-1] == '\n'
+
+	lang := cmd.lang
+	if lang == "" && cmd.headers {
+		lang = "http"
+	}
+	if cmd.gomod || cmd.pkgDoc {
+		lang = "raw"
+	}
+	defaults := e.extDefaults[strings.ToLower(path.Ext(base))]
+	if lang == "" {
+		lang = defaults.Lang
+	}
+	if lang == "" {
+		lang = strings.TrimPrefix(path.Ext(base), ".")
+		if e.requireLang && !knownExtensions[lang] {
+			lang = ""
+		}
+	}
+	if lang == "" {
+		lang = shebangLang(src)
+	}
+	if lang == "" && e.contentLang {
+		lang = contentLang(b)
+	}
+	table := cmd.table || cmd.structDoc != ""
+	if (e.binaryFallback || defaults.BinaryFallback) && !table && lang != "raw" && lang != "text" && looksBinary(b) {
+		e.logf("%d: %s does not look like text, embedding it as text instead of %q", cmd.line, cmd.path, lang)
+		lang, b = "text", printable(b)
+	}
+	if lang == "" && e.requireLang && !table {
+		return fmt.Errorf("missing language for %s, it cannot be guessed", cmd.path)
+	}
+	if e.verifyGo && lang == "go" && !table {
+		if err := verifyGo(b); err != nil {
+			return fmt.Errorf("invalid Go code in %s: %v", cmd.path, err)
+		}
+	}
+	if e.report != nil {
+		e.report.Directives = append(e.report.Directives, DirectiveReport{
+			Line: cmd.line, Path: cmd.path, Lang: lang, Bytes: len(b),
+		})
+	}
+	if e.onResolve != nil {
+		e.onResolve(&Command{Line: cmd.line, Path: e.resolve(cmd.path), Lang: lang, Args: cmd.args}, len(b))
+	}
+	if e.linkedCaption && !cmd.gomod {
+		if link := captionLink(cmd.shownPath); link != "" {
+			fmt.Fprintf(w, "[%s](%s)\n", cmd.shownPath, link)
+		}
+	}
+	if !mtime.IsZero() {
+		fmt.Fprintf(w, "%s%s -->\n", mtimePrefix, mtime.UTC().Format(time.RFC3339))
+	}
+	// the parser skips the details element of a previous run for cmd.
+	if cmd.collapse == "" && !cmd.gomod {
+		cmd.collapse = e.summary
+	}
+	if cmd.collapse != "" && !cmd.gomod {
+		fmt.Fprintf(w, "%s%s</summary>\n\n", detailsOpen, html.EscapeString(cmd.collapse))
+		defer fmt.Fprintf(w, "\n%s\n", detailsClose)
+	}
+	if table {
+		return writeTable(w, b)
+	}
+	raw := lang == "raw"
+	if raw {
+		if b, err = e.processRaw(cmd.path, b); err != nil {
+			return err
+		}
+		if e.escapeRaw {
+			b = escapeMarkdown(b)
+		}
+	}
+	markerRE := e.markers.lineRE()
+	dropMarkers := cmd.sample != "" && !e.keepMarkers
+	scanner := newLineScanner(bytes.NewReader(b), e.maxLineLength)
+	var code, blamed []string
+	for n := 0; scanner.Scan(); n++ {
+		t := scanner.Text()
+		if dropMarkers && markerRE.MatchString(t) {
+			continue
+		}
+		code = append(code, scanner.Text())
+		if n < len(hashes) {
+			blamed = append(blamed, hashes[n])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read %s: %v", cmd.path, err)
+	}
+	if cmd.context > 0 {
+		var before, after []string
+		for _, line := range ctx.before {
+			if !dropMarkers || !markerRE.MatchString(line) {
+				before = append(before, line)
+			}
+		}
+		for _, line := range ctx.after {
+			if !dropMarkers || !markerRE.MatchString(line) {
+				after = append(after, line)
+			}
+		}
+		code = append(append(before, code...), after...)
+	}
+	if e.redactRE != nil {
+		code = redact(code, e.redactRE)
+	}
+	var truncated bool
+	total := len(code)
+	switch {
+	case e.previewLines > 0 && cmd.head == 0 && cmd.tail == 0 && len(code) > e.previewLines:
+		code = code[:e.previewLines]
+		if len(blamed) > e.previewLines {
+			blamed = blamed[:e.previewLines]
+		}
+	case cmd.head > 0 && len(code) > cmd.head:
+		code, truncated = code[:cmd.head], true
+		if len(blamed) > cmd.head {
+			blamed = blamed[:cmd.head]
+		}
+	case cmd.tail > 0 && len(code) > cmd.tail:
+		code, truncated = code[len(code)-cmd.tail:], true
+		if len(blamed) > cmd.tail {
+			blamed = blamed[len(blamed)-cmd.tail:]
+		}
+	}
+	if cmd.colFrom > 0 {
+		code = sliceColumns(code, cmd.colFrom, cmd.colTo)
+	}
+	if !raw {
+		mode := e.normalizeMode(lang)
+		if defaults.Normalize != nil {
+			mode = *defaults.Normalize
+		}
+		code = normalize(code, mode, e.indentIgnore)
+	}
+	if e.stripComments {
+		code = stripCommentPrefix(code)
+	}
+	if e.tabWidth > 0 && !raw {
+		var skip map[int]bool
+		if lang == "go" {
+			skip = goLiteralLines(code)
+		}
+		code = expandTabs(code, e.tabWidth, skip)
+	}
+	if !raw {
+		code = reindent(code, e.reindent)
+	}
+	if e.trimDangling && lang == "go" {
+		code = trimDanglingToken(code)
+	}
+	for i, hash := range blamed {
+		if code[i] == "" {
+			code[i] = hash
+			continue
+		}
+		code[i] = hash + " " + code[i]
+	}
+	if e.wrapMain && lang == "go" && !hasPackageClause(code) {
+		code = wrapMain(code)
+	}
+	ts := e.transformers
+	if cmd.sort {
+		ts = append(ts[:len(ts):len(ts)], Sort())
+	}
+	if cmd.uniq {
+		ts = append(ts[:len(ts):len(ts)], Uniq())
+	}
+	for _, t := range ts {
+		if code, err = t.Transform(code); err != nil {
+			return fmt.Errorf("could not transform content from %s: %v", cmd.path, err)
+		}
+	}
+	if cmd.out != "" {
+		if err := e.writeSnippet(cmd.out, code, terminated); err != nil {
+			return err
+		}
+	}
+	if ctx.moreBefore || truncated && cmd.tail > 0 {
+		code = append([]string{"..."}, code...)
+	}
+	if ctx.moreAfter || truncated && cmd.head > 0 {
+		code = append(code, "...")
+	}
+	if cmd.highlight != "" && raw {
+		return errors.New("highlight cannot be used without a code fence")
+	}
+	if cmd.lastLine > len(code) {
+		return fmt.Errorf("cannot highlight line %d of %s, only %d lines are embedded", cmd.lastLine, cmd.path, len(code))
+	}
+	// gomod embeds a version, not raw content, which is found again by its form.
+	delimited := raw && !cmd.gomod
+	if delimited {
+		fmt.Fprintln(w, rawBegin)
+	}
+	if !raw {
+		info := lang
+		if cmd.highlight != "" {
+			info += " {" + cmd.highlight + "}"
+		}
+		if e.indentAnnotation {
+			if style := indentStyle(code); style != "" {
+				info += " {indent=" + style + "}"
+			}
+		}
+		fmt.Fprintln(w, "```"+info)
+	}
+	prefix := e.linePrefix
+	if cmd.prefix != "" {
+		prefix = cmd.prefix
+	}
+	size := 0
+	for _, c := range code {
+		if c != "" || e.prefixBlankLines {
+			c = prefix + c
+		}
+		fmt.Fprintln(w, c)
+		size += len(c) + 1
+	}
+	if delimited {
+		fmt.Fprintln(w, rawEnd)
+	}
+	if !raw {
+		fmt.Fprintln(w, "```")
+		if e.blockSummary {
+			fmt.Fprintf(w, "<!-- %s, %s -->\n", plural(len(code), "line"), plural(size, "byte"))
+		}
+	}
+	if total > len(code) && e.previewLines > 0 && cmd.head == 0 && cmd.tail == 0 {
+		link := strings.Replace(e.previewLink, "{path}", strings.Replace(cmd.shownPath, `\`, "/", -1), -1)
+		fmt.Fprintf(w, "[Show all %d lines](%s)\n", total, link)
+	}
+	if e.playgroundLink && lang == "go" && !raw && hasPackageClause(code) {
+		link, err := e.sharePlayground(strings.Join(code, "\n") + "\n")
+		if err != nil {
+			e.logf("%d: could not share %s on the Go Playground: %v", cmd.line, cmd.path, err)
+			return nil
+		}
+		fmt.Fprintf(w, "[Run in the Go Playground](%s)\n", link)
+	}
+	return nil
+}
+
+// WithMaxLineLength limits the length of the lines of the markdown and of the
+// embedded content to n bytes, longer lines being an error. By default, lines
+// can be of any length.
+func WithMaxLineLength(n int) Option {
+	return Option{func(e *embedder) { e.maxLineLength = n }}
+}
+
+// WithAllowFileOutput enables the out argument, which also writes the embedded
+// lines to a file, given relative to the base directory:
+//
+//	[embedmd]:# (src.go go /func main/ $ out=snippets/main.go)
+func WithAllowFileOutput(allow bool) Option {
+	return Option{func(e *embedder) { e.fileOutput = allow }}
+}
+
+// writeSnippet writes the embedded lines code to the file at path, relative to
+// the base directory, creating its directory if needed. The file ends with a
+// newline if the content is terminated by one.
+func (e *embedder) writeSnippet(path string, code []string, terminated bool) error {
+	if !e.fileOutput {
+		return errors.New("file output is not enabled")
+	}
+	if e.dryRun {
+		return nil
+	}
+	path = localPath(e.baseDir, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not write %s: %v", path, err)
+	}
+	var content string
+	if len(code) > 0 {
+		content = strings.Join(code, "\n")
+		if terminated {
+			content += "\n"
+		}
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("could not write %s: %v", path, err)
+	}
+	return nil
+}
+
+// WithTabWidth replaces the tabs indenting the embedded lines with n spaces
+// each. Tabs after the indentation are kept, and so is the indentation of the
+// lines of Go raw string literals.
+func WithTabWidth(n int) Option {
+	return Option{func(e *embedder) { e.tabWidth = n }}
+}
+
+// WithRedactPatterns replaces with *** the rest of the embedded lines after the
+// first of the given patterns they contain, such as "password=" or "token:",
+// to keep secrets out of the documentation. Patterns are matched ignoring case.
+func WithRedactPatterns(patterns []string) Option {
+	return Option{func(e *embedder) {
+		if len(patterns) == 0 {
+			e.redactRE = nil
+			return
+		}
+		quoted := make([]string, len(patterns))
+		for i, p := range patterns {
+			quoted[i] = regexp.QuoteMeta(p)
+		}
+		e.redactRE = regexp.MustCompile(`(?i)` + strings.Join(quoted, "|"))
+	}}
+}
+
+// redact replaces with *** what follows the first match of re, and the blanks
+// after it, in every line, unless nothing else does.
+func redact(s []string, re *regexp.Regexp) []string {
+	for i, line := range s {
+		loc := re.FindStringIndex(line)
+		if loc == nil {
+			continue
+		}
+		end := len(line) - len(strings.TrimLeft(line[loc[1]:], " \t"))
+		if end < len(line) {
+			s[i] = line[:end] + "***"
+		}
+	}
+	return s
+}
+
+// WithBlockSummary adds after every code fence a comment giving the number of
+// lines and bytes of its content, such as:
+//
+//	<!-- 23 lines, 512 bytes -->
+func WithBlockSummary(summary bool) Option {
+	return Option{func(e *embedder) { e.blockSummary = summary }}
+}
+
+// blockSummaryRE matches the comments added by WithBlockSummary.
+var blockSummaryRE = regexp.MustCompile(`^<!-- \d+ lines?, \d+ bytes? -->$`)
+
+// WithPreview embeds at most the first n lines of content longer than that,
+// followed by a link to the full content built from linkTmpl by replacing
+// {path} with the path of the embedded file, as rewritten by WithPathRewrite,
+// such as:
+//
+//	[Show all 120 lines](https://github.com/owner/repo/blob/main/{path})
+//
+// Commands using head or tail are not previewed.
+func WithPreview(lines int, linkTmpl string) Option {
+	return Option{func(e *embedder) { e.previewLines, e.previewLink = lines, linkTmpl }}
+}
+
+// previewLinkRE matches the links added by WithPreview.
+var previewLinkRE = regexp.MustCompile(`^\[Show all \d+ lines\]\(.*\)$`)
+
+// plural returns n followed by the given word, in plural unless n is 1.
+func plural(n int, word string) string {
+	if n == 1 {
+		return "1 " + word
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
+
+// WithIndentAnnotation notes in the info string of code fences whether the
+// embedded lines are indented with tabs, spaces or both, as in:
+//
+//	```go {indent=tabs}
+func WithIndentAnnotation(annotate bool) Option {
+	return Option{func(e *embedder) { e.indentAnnotation = annotate }}
+}
+
+// indentStyle returns "tabs", "spaces" or "mixed" depending on the characters
+// indenting the lines, or "" if none is indented.
+func indentStyle(s []string) string {
+	var tabs, spaces bool
+	for _, line := range s {
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if indent == line {
+			continue // blank lines do not count.
+		}
+		tabs = tabs || strings.Contains(indent, "\t")
+		spaces = spaces || strings.Contains(indent, " ")
+	}
+	switch {
+	case tabs && spaces:
+		return "mixed"
+	case tabs:
+		return "tabs"
+	case spaces:
+		return "spaces"
+	}
+	return ""
+}
+
+// expandTabs replaces the leading tabs of the lines with n spaces each, except
+// for the lines whose index is in skip.
+func expandTabs(s []string, n int, skip map[int]bool) []string {
+	indent := strings.Repeat(" ", n)
+	for i, line := range s {
+		if skip[i] {
+			continue
+		}
+		tabs := len(line) - len(strings.TrimLeft(line, "\t"))
+		s[i] = strings.Repeat(indent, tabs) + line[tabs:]
+	}
+	return s
+}
+
+// sliceColumns keeps the runes of each line from column from to column to,
+// starting at 1 and both included. If to is zero, lines are kept until their
+// end.
+func sliceColumns(s []string, from, to int) []string {
+	for i, line := range s {
+		r := []rune(line)
+		end := len(r)
+		if to > 0 && to < end {
+			end = to
+		}
+		if from > end {
+			s[i] = ""
+			continue
+		}
+		s[i] = string(r[from-1 : end])
+	}
+	return s
+}
+
+// reindent adds n spaces at the beginning of every non empty line.
+func reindent(s []string, n int) []string {
+	if n <= 0 {
+		return s
+	}
+	indent := strings.Repeat(" ", n)
+	for i, line := range s {
+		if line != "" {
+			s[i] = indent + line
+		}
+	}
+	return s
+}
+
+// WithStripLicenseHeader removes the comment block starting whole files
+// embedded without selecting a part of them, such as a license header. Only
+// blocks followed by a blank line are removed, to keep the doc comments of the
+// code that follows.
+func WithStripLicenseHeader(strip bool) Option {
+	return Option{func(e *embedder) { e.stripLicense = strip }}
+}
+
+// stripLicenseHeader removes the block of // or # comments, or the /* */
+// comment, starting b after an optional shebang line, and the blank lines
+// following it. The block is kept if it is not followed by a blank line.
+func stripLicenseHeader(b []byte) []byte {
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	i := 0
+	if len(lines) > 0 && bytes.HasPrefix(lines[0], []byte("#!")) {
+		i++
+	}
+	start, end := i, i
+	if end < len(lines) && bytes.HasPrefix(bytes.TrimSpace(lines[end]), []byte("/*")) {
+		for end < len(lines) && !bytes.Contains(lines[end], []byte("*/")) {
+			end++
+		}
+		if end == len(lines) {
+			return b
+		}
+		end++
+	} else {
+		for end < len(lines) && isLineComment(bytes.TrimSpace(lines[end])) {
+			end++
+		}
+	}
+	if end == start || end == len(lines) || len(bytes.TrimSpace(lines[end])) != 0 {
+		return b
+	}
+	for end < len(lines) && len(bytes.TrimSpace(lines[end])) == 0 {
+		end++
+	}
+	return append(bytes.Join(lines[:start], nil), bytes.Join(lines[end:], nil)...)
+}
+
+// isLineComment reports whether the trimmed line is a // or # comment. Lines
+// like #include, starting with # but not followed by a blank, are not.
+func isLineComment(line []byte) bool {
+	return bytes.HasPrefix(line, []byte("//")) || bytes.Equal(line, []byte("#")) ||
+		bytes.HasPrefix(line, []byte("# ")) || bytes.HasPrefix(line, []byte("#\t"))
+}
+
+// WithStripCommentPrefix removes the comment prefix, such as "// " or " * ",
+// starting all the non empty embedded lines. This is useful to embed the prose
+// of a comment.
+func WithStripCommentPrefix(strip bool) Option {
+	return Option{func(e *embedder) { e.stripComments = strip }}
+}
+
+// commentPrefixes are the prefixes removed by stripCommentPrefix, in order of
+// preference.
+var commentPrefixes = []string{"// ", " * ", "* ", "# "}
+
+// stripCommentPrefix removes the first of commentPrefixes starting all the non
+// empty lines. The trailing space of the prefix can be missing in empty
+// comment lines and lines indented with a tab.
+func stripCommentPrefix(s []string) []string {
+	for _, prefix := range commentPrefixes {
+		bare := strings.TrimRight(prefix, " ")
+		found, ok := false, true
+		for _, line := range s {
+			if line == "" {
+				continue
+			}
+			if !strings.HasPrefix(line, prefix) && line != bare && !strings.HasPrefix(line, bare+"\t") {
+				ok = false
+				break
+			}
+			found = true
+		}
+		if !found || !ok {
+			continue
+		}
+		for i, line := range s {
+			if strings.HasPrefix(line, prefix) {
+				s[i] = line[len(prefix):]
+			} else {
+				s[i] = strings.TrimPrefix(line, bare)
+			}
+		}
+		return s
 	}
 	return s
 }
 
-func extract(b []byte, sample string) ([]byte, error) {
-	start := "START " + sample
-	end := "END " + sample
+// A NormalizeMode indicates which indentation is removed from embedded code.
+type NormalizeMode int
+
+const (
+	// NormalizeNone keeps the indentation as is.
+	NormalizeNone NormalizeMode = iota
+	// NormalizeTabs removes the tabs indenting all the lines.
+	NormalizeTabs
+	// NormalizeSpaces removes the spaces indenting all the lines.
+	NormalizeSpaces
+	// NormalizeCommon removes the blanks, tabs or spaces, indenting all the
+	// lines.
+	NormalizeCommon
+)
+
+// WithReplacements replaces every occurrence of the keys of the given map, such
+// as __API_KEY__, with their value in the embedded content. Longer keys are
+// replaced first when several of them match at the same position.
+func WithReplacements(replacements map[string]string) Option {
+	var keys []string
+	for k := range replacements {
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	var oldnew []string
+	for _, k := range keys {
+		oldnew = append(oldnew, k, replacements[k])
+	}
+	return Option{func(e *embedder) {
+		e.replacer = nil
+		if len(oldnew) > 0 {
+			e.replacer = strings.NewReplacer(oldnew...)
+		}
+	}}
+}
+
+// WithGitBlame enables the blame keyword, which runs git to annotate embedded
+// lines with the commit that last changed them.
+func WithGitBlame(enabled bool) Option {
+	return Option{func(e *embedder) { e.gitBlame = enabled }}
+}
+
+// WithGitDiff enables the since argument, which runs git diff to embed the
+// lines added to a file since a revision.
+func WithGitDiff(enabled bool) Option {
+	return Option{func(e *embedder) { e.gitDiff = enabled }}
+}
+
+// WithRequireTracked makes it an error to embed local files that are in a git
+// work tree but not tracked by git, such as build artifacts. It runs git for
+// every local file embedded.
+func WithRequireTracked(require bool) Option {
+	return Option{func(e *embedder) { e.requireTracked = require }}
+}
+
+// WithRequireLanguage makes commands without a language fail, unless it can be
+// guessed reliably from the extension of the file or its shebang line.
+func WithRequireLanguage(require bool) Option {
+	return Option{func(e *embedder) { e.requireLang = require }}
+}
+
+// WithReproducible makes the output depend only on the input and the embedded
+// content: WithTimestampFooter and WithSourceTimestamp are ignored, and all
+// lines end with \n, even when using Update or WithOutputNewline. Output built from maps, such as
+// embedded response headers and the issues found by ValidateMarkers, is
+// always sorted.
+func WithReproducible(reproducible bool) Option {
+	return Option{func(e *embedder) { e.reproducible = reproducible }}
+}
+
+// A Command describes an embedmd command found in a markdown document.
+type Command struct {
+	Line int    // line of the command in the document.
+	Path string // path or URL of the embedded content, as resolved.
+	Lang string // language of the code block.
+	Args string // arguments of the command, as written.
+}
+
+// WithOnResolve calls f for each command run, with the size of the content
+// extracted for it. This is useful to know which files a document depends on.
+func WithOnResolve(f func(cmd *Command, size int)) Option {
+	return Option{func(e *embedder) { e.onResolve = f }}
+}
+
+// resolve returns the path of the local file for the given command path,
+// relative to the base directory, or the path itself for URLs.
+func (e *embedder) resolve(path string) string {
+	if !isLocalPath(path) {
+		return path
+	}
+	return localPath(e.baseDir, path)
+}
+
+// WithLogger sets the logger used to report warnings. By default, they are
+// written by the standard logger.
+func WithLogger(l *log.Logger) Option {
+	return Option{func(e *embedder) { e.logger = l }}
+}
+
+// logf reports a warning.
+func (e *embedder) logf(format string, args ...interface{}) {
+	if e.logger != nil {
+		e.logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// WithWarnOnTypos reports the lines that look like mistyped commands, such as
+// [embedmd]: (file.go) or [embemd]:# (file.go), with the logger. These lines
+// are not run.
+func WithWarnOnTypos(warn bool) Option {
+	return Option{func(e *embedder) { e.warnTypos = warn }}
+}
+
+// WithDedupAdjacentBlocks omits the content generated for a command when it is
+// identical to the one generated for the previous command, and they are
+// separated only by blank lines, as with duplicated commands in templated
+// documents.
+func WithDedupAdjacentBlocks(dedup bool) Option {
+	return Option{func(e *embedder) { e.dedupBlocks = dedup }}
+}
+
+// adjacentBlocks writes to w the markdown of a document, omitting the output of
+// commands identical to the one of the previous command.
+type adjacentBlocks struct {
+	w     io.Writer
+	last  []byte       // output of the previous command, if any.
+	since bytes.Buffer // markdown written since the previous command output.
+}
+
+func (a *adjacentBlocks) Write(p []byte) (int, error) {
+	a.since.Write(p)
+	return a.w.Write(p)
+}
+
+// dedup returns a commandRunner writing the output of run unless it is a
+// duplicate of the previous one.
+func (a *adjacentBlocks) dedup(run commandRunner) commandRunner {
+	return func(w io.Writer, cmd *command) error {
+		var buf bytes.Buffer
+		err := run(&buf, cmd)
+		dup := err == nil && a.last != nil && bytes.Equal(buf.Bytes(), a.last) && onlyCommand(a.since.Bytes())
+		if !dup {
+			if _, werr := a.w.Write(buf.Bytes()); err == nil {
+				err = werr
+			}
+		}
+		a.last = buf.Bytes()
+		a.since.Reset()
+		return err
+	}
+}
+
+// onlyCommand reports whether the given markdown is made of blank lines and a
+// single line holding a command.
+func onlyCommand(md []byte) bool {
+	commands := 0
+	for _, line := range bytes.Split(md, []byte("\n")) {
+		switch line = bytes.TrimSpace(line); {
+		case len(line) == 0:
+		case bytes.HasPrefix(line, []byte(commandPrefix)):
+			commands++
+		default:
+			return false
+		}
+	}
+	return commands == 1
+}
+
+// WithContentLangDetection guesses from their content, as a last resort, the
+// language of files whose extension and shebang line do not give one: JSON,
+// HTML, XML or YAML.
+func WithContentLangDetection(detect bool) Option {
+	return Option{func(e *embedder) { e.contentLang = detect }}
+}
+
+// WithBinaryFallback embeds content that does not look like text, such as
+// binary files, in a text code fence instead of the one of its language, and
+// logs a warning. The NUL bytes, other control characters and invalid UTF-8 of
+// the content are replaced by U+FFFD.
+func WithBinaryFallback(fallback bool) Option {
+	return Option{func(e *embedder) { e.binaryFallback = fallback }}
+}
+
+// WithTrimDanglingTokens removes the comma or opening brace ending the last
+// line of embedded Go code, as in regions ending in the middle of a composite
+// literal or before the body of a function.
+func WithTrimDanglingTokens(trim bool) Option {
+	return Option{func(e *embedder) { e.trimDangling = trim }}
+}
+
+// WithWrapMain wraps embedded Go code without a package clause, such as bare
+// statements, in a main function of a main package so it reads as a runnable
+// program. Code made of top-level declarations, such as a function embedded
+// with func=, only gets the package clause.
+func WithWrapMain(wrap bool) Option {
+	return Option{func(e *embedder) { e.wrapMain = wrap }}
+}
+
+// WithShowImports adds, above Go functions embedded with the func argument, an
+// import block listing the packages they use.
+func WithShowImports(show bool) Option {
+	return Option{func(e *embedder) { e.showImports = show }}
+}
+
+// WithNormalizePolicy sets how indentation is removed for each language, as
+// named in the commands or given by the file extension. Languages not in the
+// map use NormalizeCommon. Without a policy, NormalizeTabs is used for all
+// languages.
+func WithNormalizePolicy(policy map[string]NormalizeMode) Option {
+	return Option{func(e *embedder) { e.normalizePolicy = policy }}
+}
+
+// ExtOptions are the defaults used, with WithExtensionDefaults, for the files
+// with a given extension.
+type ExtOptions struct {
+	// Lang is the language of the code fence, unless the command names one.
+	Lang string
+	// Normalize, if not nil, sets how indentation is removed instead of the
+	// policy given with WithNormalizePolicy.
+	Normalize *NormalizeMode
+	// BinaryFallback embeds content that does not look like text in a text
+	// code fence, as WithBinaryFallback does.
+	BinaryFallback bool
+}
+
+// WithExtensionDefaults sets the defaults used for the files with each of the
+// extensions in the map, such as ".py", without repeating them in every
+// command. Extensions are compared ignoring case.
+func WithExtensionDefaults(defaults map[string]ExtOptions) Option {
+	return Option{func(e *embedder) {
+		e.extDefaults = make(map[string]ExtOptions, len(defaults))
+		for ext, opts := range defaults {
+			e.extDefaults[strings.ToLower(ext)] = opts
+		}
+	}}
+}
+
+// WithIndentIgnorePattern excludes the lines matching re, such as comments
+// starting at column 0, when computing the indentation removed from embedded
+// code. These lines are still embedded, keeping what they have of it.
+func WithIndentIgnorePattern(re *regexp.Regexp) Option {
+	return Option{func(e *embedder) { e.indentIgnore = re }}
+}
+
+// normalizeMode returns the NormalizeMode to use for the given language.
+func (e *embedder) normalizeMode(lang string) NormalizeMode {
+	if e.normalizePolicy == nil {
+		return NormalizeTabs
+	}
+	if mode, ok := e.normalizePolicy[lang]; ok {
+		return mode
+	}
+	return NormalizeCommon
+}
+
+// normalize removes the indentation shared by all the non empty lines. Lines
+// matching ignore, if not nil, do not count when computing the indentation and
+// only lose the part of it they start with.
+func normalize(s []string, mode NormalizeMode, ignore *regexp.Regexp) []string {
+	var indent string
+	switch mode {
+	case NormalizeTabs:
+		indent = commonIndent(s, "\t", ignore)
+	case NormalizeSpaces:
+		indent = commonIndent(s, " ", ignore)
+	case NormalizeCommon:
+		indent = commonIndent(s, " \t", ignore)
+	}
+	if indent == "" {
+		return s
+	}
+	for i, line := range s {
+		if line == "" {
+			continue
+		}
+		n := 0
+		for n < len(indent) && n < len(line) && line[n] == indent[n] {
+			n++
+		}
+		s[i] = line[n:]
+	}
+	return s
+}
+
+// commonIndent returns the longest prefix of characters in cutset shared by
+// all the non empty lines not matching ignore.
+func commonIndent(s []string, cutset string, ignore *regexp.Regexp) string {
+	indent, first := "", true
+	for _, line := range s {
+		if line == "" || ignore != nil && ignore.MatchString(line) {
+			continue
+		}
+		blanks := line[:len(line)-len(strings.TrimLeft(line, cutset))]
+		if first {
+			indent, first = blanks, false
+			continue
+		}
+		for !strings.HasPrefix(blanks, indent) {
+			indent = indent[:len(indent)-1]
+		}
+	}
+	return indent
+}
+
+// A regionContext holds the lines around an embedded region of a file.
+type regionContext struct {
+	before, after         []string
+	moreBefore, moreAfter bool // whether there are more lines in the file.
+}
+
+// offsetIn returns the offset in src of the content b extracted from it, or -1
+// if b is not a part of src. The extraction functions return slices of their
+// input, which locates the content exactly even when its text is found
+// earlier in src.
+func offsetIn(src, b []byte) int {
+	i := cap(src) - cap(b)
+	if len(b) == 0 || i < 0 || i+len(b) > len(src) || &src[i] != &b[0] {
+		return -1
+	}
+	return i
+}
+
+// contextLines returns up to n lines of src before and after the lines of the
+// region b.
+func contextLines(src, b []byte, n int) (regionContext, error) {
+	i := offsetIn(src, b)
+	if i < 0 {
+		return regionContext{}, errors.New("could not locate the embedded lines")
+	}
+	lines := strings.Split(strings.TrimSuffix(string(src), "\n"), "\n")
+	first := bytes.Count(src[:i], []byte("\n"))
+	last := first + bytes.Count(bytes.TrimSuffix(b, []byte("\n")), []byte("\n"))
+
+	from, to := first-n, last+1+n
+	if from < 0 {
+		from = 0
+	}
+	if to > len(lines) {
+		to = len(lines)
+	}
+	return regionContext{
+		before:     lines[from:first],
+		after:      lines[last+1 : to],
+		moreBefore: from > 0,
+		moreAfter:  to < len(lines),
+	}, nil
+}
+
+// extractSamples returns the samples with the given comma separated names, in
+// order and separated by a line holding the separator set by
+// WithSampleSeparator, empty by default.
+func (e *embedder) extractSamples(b []byte, names string) ([]byte, error) {
+	var res []byte
+	for i, name := range strings.Split(names, ",") {
+		sample, err := extract(b, name, e.markers)
+		if err != nil {
+			return nil, err
+		}
+		if e.keepMarkers {
+			sample = wholeLines(b, sample)
+		}
+		switch i {
+		case 0:
+			// a single sample is returned as a part of b, so it can be located.
+			res = sample
+			continue
+		case 1:
+			res = append([]byte(nil), res...)
+		}
+		if len(res) > 0 && res[len(res)-1] != '\n' {
+			res = append(res, '\n')
+		}
+		res = append(append(res, e.sampleSeparator...), '\n')
+		res = append(res, sample...)
+	}
+	return res, nil
+}
+
+// WithSampleSeparator sets the line written between the samples embedded by a
+// command naming several of them. By default, they are separated by a blank
+// line.
+func WithSampleSeparator(sep string) Option {
+	return Option{func(e *embedder) { e.sampleSeparator = sep }}
+}
+
+// extract returns the text from the START marker of the given sample up to
+// its END marker.
+func extract(b []byte, sample string, m markers) ([]byte, error) {
+	return extractRegexp(b, m.keyword("START")+" "+sample, m.keyword("END")+" "+sample)
+}
+
+// WithPathRewrite sets a function rewriting the paths of embedded files where
+// they appear in the output: in captions, source map and missing file
+// comments. It can hide internal paths in published documents. Error messages
+// keep the actual paths.
+func WithPathRewrite(rewrite func(path string) string) Option {
+	return Option{func(e *embedder) { e.pathRewrite = rewrite }}
+}
+
+// WithEscapeRaw escapes with backslashes the characters of content embedded
+// raw that markdown would interpret, such as * and _, so it renders as plain
+// text.
+func WithEscapeRaw(escape bool) Option {
+	return Option{func(e *embedder) { e.escapeRaw = escape }}
+}
+
+// WithOutputNewline sets the line ending of the content generated for
+// commands: "lf", the default, or "crlf" for documents using Windows line
+// endings. The rest of the document is written as read.
+func WithOutputNewline(style string) Option {
+	return Option{func(e *embedder) { e.newline = style }}
+}
+
+// A crlfWriter writes to w what is written to it, replacing line feeds not
+// already preceded by carriage returns with CRLF.
+type crlfWriter struct {
+	w      io.Writer
+	lastCR bool // whether the last byte written was a carriage return.
+}
+
+func (c *crlfWriter) Write(p []byte) (int, error) {
+	var b []byte
+	for _, r := range p {
+		if r == '\n' && !c.lastCR {
+			b = append(b, '\r')
+		}
+		b = append(b, r)
+		c.lastCR = r == '\r'
+	}
+	if _, err := c.w.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WithAllowEmptyMatch embeds empty content, and logs a warning, when the sample
+// or the regular expressions of a command match nothing, instead of failing.
+// This allows referring to regions that are not written yet.
+func WithAllowEmptyMatch(allow bool) Option {
+	return Option{func(e *embedder) { e.allowEmptyMatch = allow }}
+}
+
+// WithBreadcrumb adds, above sections of markdown documents selected with the
+// fragment of their URL, a quote listing the headings leading to it, as in
+// > From: Guide > Setup.
+func WithBreadcrumb(breadcrumb bool) Option {
+	return Option{func(e *embedder) { e.breadcrumb = breadcrumb }}
+}
+
+// WithLinkedCaption adds above the embedded content a link to its source, such
+// as [docs/code.go](docs/code.go). Local files are linked relative to the
+// document, and other sources only if they are http or https URLs.
+func WithLinkedCaption(caption bool) Option {
+	return Option{func(e *embedder) { e.linkedCaption = caption }}
+}
+
+// captionLink returns the link target of the caption of the content embedded
+// from path, or "" if it cannot be linked to.
+func captionLink(path string) string {
+	switch {
+	case strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://"):
+		return strings.Replace(path, " ", "%20", -1)
+	case !isLocalPath(path):
+		return ""
+	}
+	return strings.Replace(strings.Replace(path, `\`, "/", -1), " ", "%20", -1)
+}
+
+// WithKeepRegionMarkers keeps the lines holding the START and END markers of
+// the embedded samples, which are removed by default, as when documenting the
+// marker syntax itself.
+func WithKeepRegionMarkers(keep bool) Option {
+	return Option{func(e *embedder) { e.keepMarkers = keep }}
+}
+
+// wholeLines extends the part b of src to the start of its first line and to
+// the end of its last one.
+func wholeLines(src, b []byte) []byte {
+	i := offsetIn(src, b)
+	if i < 0 {
+		return b
+	}
+	from := bytes.LastIndexByte(src[:i], '\n') + 1
+	to := i + len(b)
+	if b[len(b)-1] != '\n' {
+		if nl := bytes.IndexByte(src[to:], '\n'); nl >= 0 {
+			to += nl + 1
+		} else {
+			to = len(src)
+		}
+	}
+	return src[from:to]
+}
+
+// A noMatchError reports that the regular expression it holds matches nothing
+// in the content.
+type noMatchError string
+
+func (e noMatchError) Error() string { return fmt.Sprintf("could not match %q", string(e)) }
+
+// extractNthMatch returns the whole lines of the nth match, starting at 1, of
+// the given regular expression in b. Matches starting on the lines of the
+// previous one are not counted.
+func extractNthMatch(b []byte, expr string, n int) ([]byte, error) {
+	re, err := regexp.CompilePOSIX(expr)
+	if err != nil {
+		return nil, err
+	}
+	var lines [][]byte
+	end := 0 // end of the lines of the last match counted.
+	for _, loc := range re.FindAllIndex(b, -1) {
+		if loc[0] < end || loc[0] == loc[1] {
+			continue
+		}
+		l := wholeLines(b, b[loc[0]:loc[1]])
+		lines = append(lines, l)
+		if len(lines) == n {
+			return l, nil
+		}
+		end = offsetIn(b, l) + len(l)
+	}
+	if len(lines) == 0 {
+		return nil, noMatchError(expr)
+	}
+	return nil, fmt.Errorf("%q only matches %d times, cannot select match %d", expr, len(lines), n)
+}
+
+// extractRegexp returns the text starting at the first match of start and
+// ending at the end of the first following match of end. If end is empty,
+// only the text matching start is returned, and if end is $ the text runs
+// until the end of the content.
+func extractRegexp(b []byte, start, end string) ([]byte, error) {
 	match := func(s string) ([]int, error) {
 		re, err := regexp.CompilePOSIX(s)
 		if err != nil {
@@ -162,7 +2154,7 @@ func extract(b []byte, sample string) ([]byte, error) {
 		}
 		loc := re.FindIndex(b)
 		if loc == nil {
-			return nil, fmt.Errorf("could not match %q", s)
+			return nil, noMatchError(s)
 		}
 		return loc, nil
 	}
@@ -171,13 +2163,143 @@ func extract(b []byte, sample string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if end == "" {
+		return b[loc[0]:loc[1]], nil
+	}
+	all := b
 	b = b[loc[0]:]
+	if end == "$" {
+		return b, nil
+	}
 
 	loc, err = match(end)
 	if err != nil {
+		if re, _ := regexp.CompilePOSIX(end); re != nil && re.Match(all) {
+			return nil, fmt.Errorf("%q only matches before %q", end, start)
+		}
 		return nil, err
 	}
-	b = b[:loc[1]]
+	return b[:loc[1]], nil
+}
+
+// extractBetweenLines returns the lines after the first one equal to begin, up
+// to the following one equal to end, both excluded.
+func extractBetweenLines(b []byte, begin, end string) ([]byte, error) {
+	from, offset := -1, 0
+	for _, line := range bytes.SplitAfter(b, []byte("\n")) {
+		text := strings.TrimRight(string(line), "\r\n")
+		switch {
+		case from < 0 && text == begin:
+			from = offset + len(line)
+		case from >= 0 && text == end:
+			return b[from:offset], nil
+		}
+		offset += len(line)
+	}
+	if from < 0 {
+		return nil, noMatchError(begin)
+	}
+	return nil, noMatchError(end)
+}
 
-	return b, nil
+// extractBrace returns the lines from the one matching start up to the line
+// where the first brace opened after the match is closed. Braces inside
+// string and character literals or comments are ignored.
+func extractBrace(b []byte, start string) ([]byte, error) {
+	re, err := regexp.CompilePOSIX(start)
+	if err != nil {
+		return nil, err
+	}
+	loc := re.FindIndex(b)
+	if loc == nil {
+		return nil, noMatchError(start)
+	}
+	if block := braceBlock(b, bytes.LastIndexByte(b[:loc[0]], '\n')+1); block != nil {
+		return block, nil
+	}
+	return nil, fmt.Errorf("unbalanced braces after %q", start)
+}
+
+// extractIndentBlock returns the line matching start followed by the lines more
+// indented than it, up to the first non blank line that is not. Blank lines
+// ending the block are not included.
+func extractIndentBlock(b []byte, start string) ([]byte, error) {
+	re, err := regexp.CompilePOSIX(start)
+	if err != nil {
+		return nil, err
+	}
+	loc := re.FindIndex(b)
+	if loc == nil {
+		return nil, noMatchError(start)
+	}
+
+	from := bytes.LastIndexByte(b[:loc[0]], '\n') + 1
+	lines := bytes.SplitAfter(b[from:], []byte("\n"))
+	indent := func(line []byte) int { return len(line) - len(bytes.TrimLeft(line, " \t")) }
+	min := indent(lines[0])
+	end := from + len(lines[0])
+	for i, n := 1, from+len(lines[0]); i < len(lines); i++ {
+		line := lines[i]
+		n += len(line)
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if indent(line) <= min {
+			break
+		}
+		end = n
+	}
+	return b[from:end], nil
+}
+
+// braceBlock returns the lines from the one starting at offset from up to the
+// line where the first brace opened after it is closed, or nil if it is never
+// closed.
+func braceBlock(b []byte, from int) []byte {
+	depth := 0
+	var quote byte
+	var lineComment, blockComment bool
+	for i := from; i < len(b); i++ {
+		c := b[i]
+		next := byte(0)
+		if i+1 < len(b) {
+			next = b[i+1]
+		}
+
+		switch {
+		case lineComment:
+			lineComment = c != '\n'
+		case blockComment:
+			if c == '*' && next == '/' {
+				blockComment = false
+				i++
+			}
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '/' && next == '/':
+			lineComment = true
+			i++
+		case c == '/' && next == '*':
+			blockComment = true
+			i++
+		case c == '{':
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+			if depth > 0 {
+				continue
+			}
+			if nl := bytes.IndexByte(b[i:], '\n'); nl >= 0 {
+				return b[from : i+nl+1]
+			}
+			return b[from:]
+		}
+	}
+	return nil
 }
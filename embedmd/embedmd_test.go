@@ -14,7 +14,20 @@
 package embedmd
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
 const content = `
@@ -53,7 +66,7 @@ func TestExtract(t *testing.T) {
 
 	for _, tt := range tc {
 		t.Run(tt.name, func(t *testing.T) {
-			b, err := extract([]byte(content), tt.sample)
+			b, err := extract([]byte(content), tt.sample, markers{})
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -63,3 +76,2121 @@ func TestExtract(t *testing.T) {
 		})
 	}
 }
+
+type fakeFetcher map[string]string
+
+func (f fakeFetcher) Fetch(dir, path string) ([]byte, error) {
+	s, ok := f[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return []byte(s), nil
+}
+
+func TestTotalFetchBudget(t *testing.T) {
+	files := fakeFetcher{
+		"a.go": "// START a\nfmt.Println(\"a\")\n// END a\n",
+		"b.go": "// START b\nfmt.Println(\"b\")\n// END b\n",
+		"c.go": "// START c\nfmt.Println(\"c\")\n// END c\n",
+	}
+	in := "[embedmd]:# (a.go go a)\n\n[embedmd]:# (b.go go b)\n\n[embedmd]:# (c.go go c)\n"
+	budget := int64(len(files["a.go"]) + len(files["b.go"]))
+
+	var out bytes.Buffer
+	err := Process(&out, strings.NewReader(in), WithFetcher(files), WithTotalFetchBudget(budget))
+	if err == nil {
+		t.Fatalf("expected budget error, got output:\n%s", out.String())
+	}
+	want := "5: could not read c.go: total fetch budget of 74 bytes exceeded"
+	if err.Error() != want {
+		t.Errorf("expected error %q; got %q", want, err)
+	}
+
+	out.Reset()
+	if err := Process(&out, strings.NewReader(in), WithFetcher(files), WithTotalFetchBudget(budget+int64(len(files["c.go"])))); err != nil {
+		t.Errorf("unexpected error within budget: %v", err)
+	}
+}
+
+const cContent = `#include <stdio.h>
+
+int main(int argc, char **argv) {
+	for (int i = 0; i < argc; i++) {
+		if (argv[i][0] == '{') {
+			printf("}%s\n", argv[i]); /* } */
+		}
+	}
+	// }
+	return 0;
+}
+
+void other() {}
+`
+
+func TestExtractBrace(t *testing.T) {
+	tc := []struct {
+		name    string
+		content string
+		start   string
+		out     string
+		err     string
+	}{
+		{
+			name:  "function with nested blocks",
+			start: "int main",
+			out: `int main(int argc, char **argv) {
+	for (int i = 0; i < argc; i++) {
+		if (argv[i][0] == '{') {
+			printf("}%s\n", argv[i]); /* } */
+		}
+	}
+	// }
+	return 0;
+}
+`,
+		},
+		{
+			name:  "block closed in the same line",
+			start: "void other",
+			out:   "void other() {}\n",
+		},
+		{
+			name:  "nested block",
+			start: "if \\(",
+			out: `		if (argv[i][0] == '{') {
+			printf("}%s\n", argv[i]); /* } */
+		}
+`,
+		},
+		{
+			name:  "no match",
+			start: "int nope",
+			err:   `could not match "int nope"`,
+		},
+		{
+			name:    "unbalanced",
+			content: "#include <stdio.h>\nint main() {\n",
+			start:   "include",
+			err:     `unbalanced braces after "include"`,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			content := tt.content
+			if content == "" {
+				content = cContent
+			}
+			b, err := extractBrace([]byte(content), tt.start)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.out {
+				t.Errorf("case [%s]: expected extracting %q; got %q", tt.name, tt.out, b)
+			}
+		})
+	}
+}
+
+const yamlContent = `server:
+  port: 8080
+database:
+  primary:
+    host: db.example.com
+
+    port: 5432
+  replicas: 2
+
+logging:
+  level: info
+`
+
+func TestExtractBetweenLines(t *testing.T) {
+	const text = "intro\n--- begin ---\nfirst\n\nsecond\n--- end ---\n  --- begin ---\noutro\r\n--- begin ---\r\nwindows\r\n--- end ---\r\n"
+	tc := []struct {
+		name       string
+		begin, end string
+		out        string
+		err        string
+	}{
+		{
+			name:  "region",
+			begin: "--- begin ---", end: "--- end ---",
+			out: "first\n\nsecond\n",
+		},
+		{
+			name:  "whole lines only",
+			begin: "  --- begin ---", end: "--- end ---",
+			out: "outro\r\n--- begin ---\r\nwindows\r\n",
+		},
+		{
+			name:  "empty region",
+			begin: "second", end: "--- end ---",
+			out: "",
+		},
+		{
+			name:  "partial line",
+			begin: "--- begin", end: "--- end ---",
+			err: `could not match "--- begin"`,
+		},
+		{
+			name:  "missing end",
+			begin: "outro", end: "--- stop ---",
+			err: `could not match "--- stop ---"`,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := extractBetweenLines([]byte(text), tt.begin, tt.end)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.out {
+				t.Errorf("case [%s]: expected extracting %q; got %q", tt.name, tt.out, b)
+			}
+		})
+	}
+
+	in := "[embedmd]:# (notes.txt text begin=\"--- begin ---\" end=\"--- end ---\")\n"
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithFetcher(fakeFetcher{"notes.txt": text})); err != nil {
+		t.Fatal(err)
+	}
+	if want := in + "```text\nfirst\n\nsecond\n```\n"; out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+}
+
+func TestExtractIndentBlock(t *testing.T) {
+	tc := []struct {
+		name  string
+		start string
+		out   string
+		err   string
+	}{
+		{
+			name:  "nested mapping",
+			start: "^database:",
+			out:   "database:\n  primary:\n    host: db.example.com\n\n    port: 5432\n  replicas: 2\n",
+		},
+		{
+			name:  "indented key",
+			start: "primary:",
+			out:   "  primary:\n    host: db.example.com\n\n    port: 5432\n",
+		},
+		{
+			name:  "scalar",
+			start: "replicas",
+			out:   "  replicas: 2\n",
+		},
+		{
+			name:  "end of file",
+			start: "^logging:",
+			out:   "logging:\n  level: info\n",
+		},
+		{
+			name:  "no match",
+			start: "^cache:",
+			err:   `could not match "^cache:"`,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := extractIndentBlock([]byte(yamlContent), tt.start)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.out {
+				t.Errorf("case [%s]: expected extracting %q; got %q", tt.name, tt.out, b)
+			}
+		})
+	}
+}
+
+func TestProcessBrace(t *testing.T) {
+	files := fakeFetcher{"main.c": cContent}
+	in := "[embedmd]:# (main.c c /void other/ brace)\n"
+	want := in + "```c\nvoid other() {}\n```\n"
+
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithFetcher(files)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("expected output\n%s; got\n%s", want, out.String())
+	}
+}
+
+func TestEnsureTrailingNewline(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n\nfunc main() {}\n"}
+	fenced := "[embedmd]:# (code.go go /func main/)\n"
+	raw := "[embedmd]:# (code.go raw /func main/)\nafter\n"
+	tc := []struct {
+		name   string
+		in     string
+		ensure bool
+		out    string
+	}{
+		{name: "ensure", in: fenced, ensure: true, out: fenced + "```go\nfunc main\n```\n"},
+		{name: "verbatim", in: fenced, ensure: false, out: fenced + "```go\nfunc main\n```\n"},
+		{name: "verbatim raw", in: raw, ensure: false, out: "[embedmd]:# (code.go raw /func main/)\n<!-- embedmd:raw -->\nfunc main\n<!-- embedmd:endraw -->\nafter\n"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Process(&out, strings.NewReader(tt.in), WithFetcher(files), WithEnsureTrailingNewline(tt.ensure))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.out {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, tt.out, out.String())
+			}
+		})
+	}
+}
+
+func TestProgress(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n"}
+	in := "# doc\n\n[embedmd]:# (code.go)\n\n[embedmd]:# (code.go)\n\n```\n[embedmd]:# (not a command)\n```\n\n[embedmd]:# (code.go)\n"
+
+	var plain, withProgress bytes.Buffer
+	if err := Process(&plain, strings.NewReader(in), WithFetcher(files)); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls [][2]int
+	progress := func(done, total int) { calls = append(calls, [2]int{done, total}) }
+	if err := Process(&withProgress, strings.NewReader(in), WithFetcher(files), WithProgress(progress)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][2]int{{1, 3}, {2, 3}, {3, 3}}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("expected progress calls %v; got %v", want, calls)
+	}
+	if plain.String() != withProgress.String() {
+		t.Errorf("progress changed the output:\n%s\nvs\n%s", plain.String(), withProgress.String())
+	}
+}
+
+func TestRaw(t *testing.T) {
+	files := fakeFetcher{
+		"shared.md": "Some *shared*\n    paragraph.\n",
+		"code.go":   "func main() {\n\t// START a\n\tfmt.Println()\n\t// END a\n}\n",
+	}
+	tc := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "whole file",
+			in:   "# Title\n[embedmd]:# (shared.md raw)\nafter\n",
+			out:  "# Title\n[embedmd]:# (shared.md raw)\n<!-- embedmd:raw -->\nSome *shared*\n    paragraph.\n<!-- embedmd:endraw -->\nafter\n",
+		},
+		{
+			name: "sample",
+			in:   "[embedmd]:# (code.go raw a)\n",
+			out:  "[embedmd]:# (code.go raw a)\n<!-- embedmd:raw -->\n\tfmt.Println()\n<!-- embedmd:endraw -->\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in), WithFetcher(files)); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.out {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, tt.out, out.String())
+			}
+
+			// the raw content embedded previously is replaced.
+			var again bytes.Buffer
+			if err := Process(&again, &out, WithFetcher(files)); err != nil {
+				t.Fatal(err)
+			}
+			if again.String() != tt.out {
+				t.Errorf("case [%s]: expected output of the second run %q; got %q", tt.name, tt.out, again.String())
+			}
+		})
+	}
+}
+
+func TestLinePrefix(t *testing.T) {
+	files := fakeFetcher{"run.sh": "go build\n\n./embedmd -w docs.md\n"}
+	tc := []struct {
+		name string
+		in   string
+		opts []Option
+		out  string
+	}{
+		{
+			name: "prefix argument",
+			in:   "[embedmd]:# (run.sh lang=sh prefix=\"$ \")\n",
+			out:  "```sh\n$ go build\n\n$ ./embedmd -w docs.md\n```\n",
+		},
+		{
+			name: "prefix option",
+			in:   "[embedmd]:# (run.sh lang=sh)\n",
+			opts: []Option{WithLinePrefix("$ ")},
+			out:  "```sh\n$ go build\n\n$ ./embedmd -w docs.md\n```\n",
+		},
+		{
+			name: "argument overrides option",
+			in:   "[embedmd]:# (run.sh lang=sh prefix=\"# \")\n",
+			opts: []Option{WithLinePrefix("$ ")},
+			out:  "```sh\n# go build\n\n# ./embedmd -w docs.md\n```\n",
+		},
+		{
+			name: "prefixing blank lines",
+			in:   "[embedmd]:# (run.sh raw)\n",
+			opts: []Option{WithLinePrefix("> "), WithPrefixBlankLines(true)},
+			out:  "<!-- embedmd:raw -->\n> go build\n> \n> ./embedmd -w docs.md\n<!-- embedmd:endraw -->\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			opts := append([]Option{WithFetcher(files)}, tt.opts...)
+			if err := Process(&out, strings.NewReader(tt.in), opts...); err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestMissingFilePolicy(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n"}
+	in := "[embedmd]:# (missing.go)\n```go\nstale\n```\n\n[embedmd]:# (code.go)\n"
+	tc := []struct {
+		name   string
+		policy MissingFilePolicy
+		in     string
+		out    string
+		err    string
+	}{
+		{
+			name:   "error",
+			policy: MissingFileError,
+			in:     in,
+			err:    "1: could not read missing.go: file does not exist",
+		},
+		{
+			name:   "warn",
+			policy: MissingFileWarn,
+			in:     in,
+			out:    "[embedmd]:# (missing.go)\n<!-- missing: missing.go -->\n\n[embedmd]:# (code.go)\n```go\npackage main\n```\n",
+		},
+		{
+			name:   "warn again",
+			policy: MissingFileWarn,
+			in:     "[embedmd]:# (missing.go)\n<!-- missing: missing.go -->\n",
+			out:    "[embedmd]:# (missing.go)\n<!-- missing: missing.go -->\n",
+		},
+		{
+			name:   "skip",
+			policy: MissingFileSkip,
+			in:     in,
+			out:    "[embedmd]:# (missing.go)\n\n[embedmd]:# (code.go)\n```go\npackage main\n```\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Process(&out, strings.NewReader(tt.in), WithFetcher(files), WithMissingFilePolicy(tt.policy))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.out {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, tt.out, out.String())
+			}
+		})
+	}
+}
+
+func TestCollectErrors(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n"}
+	in := "[embedmd]:# (missing.go)\n\n[embedmd]:# (code.go)\n\n[embedmd]:# (code.go go nope)\n\n[embedmd]:# code.go\n"
+	want := "1: could not read missing.go: file does not exist\n" +
+		"5: could not extract content from code.go: could not match \"START nope\"\n" +
+		"7: argument list should be in parenthesis"
+
+	for i := 0; i < 3; i++ {
+		var out bytes.Buffer
+		err := Process(&out, strings.NewReader(in), WithFetcher(files), WithCollectErrors(true))
+		if _, ok := err.(Errors); !ok {
+			t.Fatalf("expected Errors; got %T: %v", err, err)
+		}
+		if err.Error() != want {
+			t.Errorf("run %d: expected errors\n%s\ngot\n%s", i, want, err)
+		}
+		if !strings.Contains(out.String(), "```go\npackage main\n```\n") {
+			t.Errorf("run %d: expected the valid command to be embedded; got\n%s", i, out.String())
+		}
+	}
+
+	// commands that cannot be parsed are kept as written, and the commands after
+	// them are run.
+	in = "[embedmd]:# (code.go /a/ /b/ /c/)\n\n[embedmd]:# (code.go)\n"
+	want = "1: too many regular expressions"
+	var out bytes.Buffer
+	err := Process(&out, strings.NewReader(in), WithFetcher(files), WithCollectErrors(true))
+	if err == nil || err.Error() != want {
+		t.Errorf("expected errors\n%s\ngot\n%v", want, err)
+	}
+	if want := in + "```go\npackage main\n```\n"; out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+
+	// nothing is written for the commands failing after output was generated.
+	in = "[embedmd]:# (code.go highlight=9)\n"
+	out.Reset()
+	err = Process(&out, strings.NewReader(in), WithFetcher(files), WithCollectErrors(true),
+		WithSourceMap(true), WithLinkedCaption(true), WithCollapsible("Code"))
+	if err == nil {
+		t.Errorf("expected an error for the out of range highlight")
+	}
+	if out.String() != in {
+		t.Errorf("expected output %q; got %q", in, out.String())
+	}
+}
+
+func TestSortedErrors(t *testing.T) {
+	a := &LineError{3, errors.New("a")}
+	b := &LineError{1, errors.New("b")}
+	errs := Errors{a, b, &LineError{3, errors.New("a")}, b}
+
+	got := errs.sorted()
+	if want := "1: b\n3: a"; got.Error() != want {
+		t.Errorf("expected %q; got %q", want, got.Error())
+	}
+	if errs[0] != a {
+		t.Errorf("sorting modified the original errors")
+	}
+}
+
+func TestReindent(t *testing.T) {
+	files := fakeFetcher{"code.go": "func main() {\n\t\t// START a\n\t\tif ok {\n\t\t\treturn\n\t\t}\n\n\t\tdone()\n\t\t// END a\n}\n"}
+	in := "[embedmd]:# (code.go go a)\n"
+
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithFetcher(files), WithReindent(2)); err != nil {
+		t.Fatal(err)
+	}
+	want := in + "```go\n  if ok {\n  \treturn\n  }\n\n  done()\n```\n"
+	if out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+}
+
+func TestTimestampFooter(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n"}
+	clock := func() time.Time { return time.Date(2016, 9, 1, 10, 30, 0, 0, time.FixedZone("CEST", 2*3600)) }
+	want := "# doc\n[embedmd]:# (code.go)\n```go\npackage main\n```\n<!-- embedmd: generated at 2016-09-01T08:30:00Z -->\n"
+
+	in := "# doc\n[embedmd]:# (code.go)\n"
+	for i := 0; i < 2; i++ {
+		var out bytes.Buffer
+		err := Process(&out, strings.NewReader(in), WithFetcher(files), WithTimestampFooter(true), WithClock(clock))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Errorf("run %d: expected output %q; got %q", i, want, out.String())
+		}
+		// the second run processes the output of the first one.
+		in = out.String()
+	}
+
+	// only the footer ending the document is replaced.
+	in = "# doc\n\n" + footerPrefix + "quoted -->\n\n[embedmd]:# (code.go)\n"
+	want = in + "```go\npackage main\n```\n<!-- embedmd: generated at 2016-09-01T08:30:00Z -->\n"
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithFetcher(files), WithTimestampFooter(true), WithClock(clock)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+}
+
+func TestMaxDirectives(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n"}
+	in := "[embedmd]:# (code.go)\n\n[embedmd]:# (code.go)\n\n[embedmd]:# (missing.go)\n\n[embedmd]:# (code.go)\n"
+	tc := []struct {
+		name string
+		opts []Option
+		err  string
+	}{
+		{name: "under the limit", opts: []Option{WithMaxDirectives(4), WithMissingFilePolicy(MissingFileSkip)}},
+		{name: "over the limit", opts: []Option{WithMaxDirectives(2)}, err: "5: too many commands, the limit is 2"},
+		{name: "over the limit collecting errors", opts: []Option{WithMaxDirectives(3), WithCollectErrors(true)},
+			err: "5: could not read missing.go: file does not exist\n7: too many commands, the limit is 3"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Process(&out, strings.NewReader(in), append([]Option{WithFetcher(files)}, tt.opts...)...)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("case [%s]: unexpected error %v", tt.name, err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+			}
+		})
+	}
+}
+
+func TestExtractEndBeforeStart(t *testing.T) {
+	src := "func main() {\n\t// END a\n\tfmt.Println()\n\t// START a\n}\n"
+	tc := []struct {
+		name string
+		f    func([]byte) ([]byte, error)
+		err  string
+	}{
+		{
+			name: "sample",
+			f:    func(b []byte) ([]byte, error) { return extract(b, "a", markers{}) },
+			err:  `"END a" only matches before "START a"`,
+		},
+		{
+			name: "regexp",
+			f:    func(b []byte) ([]byte, error) { return extractRegexp(b, "fmt", "main") },
+			err:  `"main" only matches before "fmt"`,
+		},
+		{
+			name: "missing end",
+			f:    func(b []byte) ([]byte, error) { return extractRegexp(b, "fmt", "nope") },
+			err:  `could not match "nope"`,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.f([]byte(src))
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+			}
+		})
+	}
+}
+
+func TestSourceTimestamp(t *testing.T) {
+	mtime := time.Date(2016, 9, 1, 8, 30, 0, 0, time.UTC)
+
+	dir, err := ioutil.TempDir("", "embedmd-mtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "code.go")
+	if err := ioutil.WriteFile(path, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/dated.go" {
+			w.Header().Set("Last-Modified", mtime.Format(http.TimeFormat))
+		}
+		fmt.Fprintln(w, "package main")
+	}))
+	defer srv.Close()
+
+	tc := []struct {
+		name string
+		path string
+		out  string
+	}{
+		{name: "local file", path: "code.go", out: "<!-- source mtime: 2016-09-01T08:30:00Z -->\n```go\npackage main\n```\n"},
+		{name: "url with Last-Modified", path: srv.URL + "/dated.go", out: "<!-- source mtime: 2016-09-01T08:30:00Z -->\n```go\npackage main\n```\n"},
+		{name: "url without Last-Modified", path: srv.URL + "/undated.go", out: "```go\npackage main\n```\n"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			in := "[embedmd]:# (" + tt.path + ")\n"
+			for i := 0; i < 2; i++ {
+				var out bytes.Buffer
+				if err := Process(&out, strings.NewReader(in), WithBaseDir(dir), WithSourceTimestamp(true)); err != nil {
+					t.Fatal(err)
+				}
+				want := "[embedmd]:# (" + tt.path + ")\n" + tt.out
+				if out.String() != want {
+					t.Errorf("case [%s] run %d: expected output %q; got %q", tt.name, i, want, out.String())
+				}
+				// the second run processes the output of the first one.
+				in = out.String()
+			}
+		})
+	}
+}
+
+func TestNormalizePolicy(t *testing.T) {
+	files := fakeFetcher{
+		"code.py":  "class A:\n    # START a\n    def f(self):\n        return 1\n    # END a\n",
+		"Makefile": "all:\n\t# START a\n\tgo build\n\tgo test\n\t# END a\n",
+		"code.go":  "func main() {\n\t// START a\n\tif ok {\n\t\treturn\n\t}\n\t// END a\n}\n",
+		"mixed.c":  "int main() {\n\t  // START a\n\t  a();\n\t  b();\n\t  // END a\n}\n",
+	}
+	policy := map[string]NormalizeMode{
+		"python":   NormalizeSpaces,
+		"makefile": NormalizeNone,
+		"go":       NormalizeTabs,
+	}
+	tc := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{name: "python uses spaces", in: "[embedmd]:# (code.py python a)\n", out: "```python\ndef f(self):\n    return 1\n```\n"},
+		{name: "makefile keeps tabs", in: "[embedmd]:# (Makefile makefile a)\n", out: "```makefile\n\tgo build\n\tgo test\n```\n"},
+		{name: "go uses tabs", in: "[embedmd]:# (code.go go a)\n", out: "```go\nif ok {\n\treturn\n}\n```\n"},
+		{name: "unknown languages use common blanks", in: "[embedmd]:# (mixed.c c a)\n", out: "```c\na();\nb();\n```\n"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in), WithFetcher(files), WithNormalizePolicy(policy)); err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestExtensionDefaults(t *testing.T) {
+	files := fakeFetcher{
+		"code.py":  "class A:\n    # START a\n    def f(self):\n        return 1\n    # END a\n",
+		"data.py":  "\x00\x01 not python",
+		"code.go":  "func main() {\n\t// START a\n\tif ok {\n\t\treturn\n\t}\n\t// END a\n}\n",
+		"image.go": "\x89PNG\r\n\x1a\n\x00",
+	}
+	// The defaults replace the policy of the languages.
+	policy := map[string]NormalizeMode{"python": NormalizeNone, "py": NormalizeNone, "go": NormalizeNone}
+	spaces, tabs := NormalizeSpaces, NormalizeTabs
+	defaults := map[string]ExtOptions{
+		".py": {Lang: "python", Normalize: &spaces, BinaryFallback: true},
+		".GO": {Normalize: &tabs},
+	}
+	tc := []struct {
+		name string
+		in   string
+		out  string
+		err  string
+	}{
+		{name: "py uses spaces", in: "[embedmd]:# (code.py /^ +def f/ /return 1/)\n", out: "```python\ndef f(self):\n    return 1\n```\n"},
+		{name: "py falls back to text", in: "[embedmd]:# (data.py)\n", out: "```text\n\ufffd\ufffd not python\n```\n"},
+		{name: "command language", in: "[embedmd]:# (code.py py /^ +def f/ /return 1/)\n", out: "```py\ndef f(self):\n    return 1\n```\n"},
+		{name: "go uses tabs", in: "[embedmd]:# (code.go /^.if ok/ /^.}/)\n", out: "```go\nif ok {\n\treturn\n}\n```\n"},
+		{name: "go has no text fallback", in: "[embedmd]:# (image.go)\n", out: "```go\n\x89PNG\n\x1a\n\x00\n```\n"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			opts := []Option{WithFetcher(files), WithLogger(log.New(ioutil.Discard, "", 0)), WithNormalizePolicy(policy), WithExtensionDefaults(defaults)}
+			if err := Process(&out, strings.NewReader(tt.in), opts...); err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tc := []struct {
+		name   string
+		mode   NormalizeMode
+		ignore *regexp.Regexp
+		in     []string
+		out    []string
+	}{
+		{name: "none", mode: NormalizeNone, in: []string{"\ta", "\t\tb"}, out: []string{"\ta", "\t\tb"}},
+		{name: "tabs", mode: NormalizeTabs, in: []string{"\t\ta", "", "\tb"}, out: []string{"\ta", "", "b"}},
+		{name: "tabs ignore spaces", mode: NormalizeTabs, in: []string{"  a", "  b"}, out: []string{"  a", "  b"}},
+		{name: "spaces", mode: NormalizeSpaces, in: []string{"    a", "  b"}, out: []string{"  a", "b"}},
+		{name: "common", mode: NormalizeCommon, in: []string{"\t  a", "\t b"}, out: []string{" a", "b"}},
+		{name: "common mismatch", mode: NormalizeCommon, in: []string{"\ta", "  b"}, out: []string{"\ta", "  b"}},
+		{name: "column 0 comment", mode: NormalizeTabs, in: []string{"// banner", "\t\ta", "\tb"}, out: []string{"// banner", "\t\ta", "\tb"}},
+		{
+			name:   "ignored column 0 comment",
+			mode:   NormalizeTabs,
+			ignore: regexp.MustCompile(`^\s*//`),
+			in:     []string{"// banner", "\t\t// a", "\t\ta", "\t\t\tb"},
+			out:    []string{"// banner", "// a", "a", "\tb"},
+		},
+		{
+			name:   "ignored line with less indentation",
+			mode:   NormalizeSpaces,
+			ignore: regexp.MustCompile(`^\s*#`),
+			in:     []string{"    a", "  # b", "      c"},
+			out:    []string{"a", "# b", "  c"},
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalize(tt.in, tt.mode, tt.ignore); !reflect.DeepEqual(got, tt.out) {
+				t.Errorf("case [%s]: expected %q; got %q", tt.name, tt.out, got)
+			}
+		})
+	}
+}
+
+func TestReplacements(t *testing.T) {
+	files := fakeFetcher{
+		"code.go": "package main\n\nconst key = \"__API_KEY__\"\nconst url = \"__API__/v1\"\n",
+	}
+	in := "[embedmd]:# (code.go)\n"
+	tc := []struct {
+		name         string
+		replacements map[string]string
+		out          string
+	}{
+		{
+			name:         "secret placeholder",
+			replacements: map[string]string{"__API_KEY__": "YOUR_KEY_HERE"},
+			out:          "```go\npackage main\n\nconst key = \"YOUR_KEY_HERE\"\nconst url = \"__API__/v1\"\n```\n",
+		},
+		{
+			name:         "overlapping keys",
+			replacements: map[string]string{"__API__": "https://example.com", "__API_KEY__": "YOUR_KEY_HERE"},
+			out:          "```go\npackage main\n\nconst key = \"YOUR_KEY_HERE\"\nconst url = \"https://example.com/v1\"\n```\n",
+		},
+		{
+			name: "no replacements",
+			out:  "```go\npackage main\n\nconst key = \"__API_KEY__\"\nconst url = \"__API__/v1\"\n```\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(in), WithFetcher(files), WithReplacements(tt.replacements)); err != nil {
+				t.Fatal(err)
+			}
+			if want := in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestStripCommentPrefix(t *testing.T) {
+	tc := []struct {
+		name string
+		in   []string
+		out  []string
+	}{
+		{name: "slashes", in: []string{"// Hello", "//", "// world"}, out: []string{"Hello", "", "world"}},
+		{name: "stars", in: []string{" * Hello", " *", " * world"}, out: []string{"Hello", "", "world"}},
+		{name: "keeps nested indentation", in: []string{"// Example:", "//", "//\tfoo()"}, out: []string{"Example:", "", "\tfoo()"}},
+		{name: "inconsistent prefix", in: []string{"// Hello", "world"}, out: []string{"// Hello", "world"}},
+		{name: "empty lines", in: []string{"", ""}, out: []string{"", ""}},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripCommentPrefix(tt.in); !reflect.DeepEqual(got, tt.out) {
+				t.Errorf("case [%s]: expected %q; got %q", tt.name, tt.out, got)
+			}
+		})
+	}
+}
+
+func TestProcessStripCommentPrefix(t *testing.T) {
+	files := fakeFetcher{
+		"code.c":  "/*\n * START doc\n * Prints a greeting.\n *\n * Returns 0.\n * END doc\n */\n",
+		"code.go": "func main() {\n\t// START doc\n\t// Prints a greeting.\n\t// END doc\n}\n",
+	}
+	tc := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{name: "stars", in: "[embedmd]:# (code.c markdown doc)\n", out: "```markdown\nPrints a greeting.\n\nReturns 0.\n```\n"},
+		{name: "slashes", in: "[embedmd]:# (code.go markdown doc)\n", out: "```markdown\nPrints a greeting.\n```\n"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in), WithFetcher(files), WithStripCommentPrefix(true)); err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestHeadTail(t *testing.T) {
+	files := fakeFetcher{"code.go": "a\nb\nc\nd\n"}
+	tc := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{name: "head", in: "[embedmd]:# (code.go head=2)\n", out: "```go\na\nb\n...\n```\n"},
+		{name: "tail", in: "[embedmd]:# (code.go tail=1)\n", out: "```go\n...\nd\n```\n"},
+		{name: "shorter than head", in: "[embedmd]:# (code.go head=10)\n", out: "```go\na\nb\nc\nd\n```\n"},
+		{name: "head of a region", in: "[embedmd]:# (code.go /b/ $ head=2)\n", out: "```go\nb\nc\n...\n```\n"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in), WithFetcher(files)); err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+
+	err := Process(ioutil.Discard, strings.NewReader("[embedmd]:# (code.go head=1 tail=1)\n"), WithFetcher(files))
+	if want := "1: head and tail cannot be used together"; err == nil || err.Error() != want {
+		t.Errorf("expected error %q; got %v", want, err)
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n", "data.csv": "a,b\n1,2\n"}
+	in := "# Title\n\n[embedmd]:# (code.go lang=go)\n\ntext\n\n[embedmd]:# (data.csv table)\n\nend\n"
+	want := "# Title\n\n[embedmd]:# (code.go lang=go)\n" +
+		"<!-- embedmd:begin code.go lang=go -->\n```go\npackage main\n```\n<!-- embedmd:end -->\n" +
+		"\ntext\n\n[embedmd]:# (data.csv table)\n" +
+		"<!-- embedmd:begin data.csv table -->\n| a | b |\n| --- | --- |\n| 1 | 2 |\n<!-- embedmd:end -->\n" +
+		"\nend\n"
+
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithFetcher(files), WithSourceMap(true)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Fatalf("expected output %q; got %q", want, out.String())
+	}
+
+	// processing the output again replaces the content between the comments.
+	files["code.go"] = "package foo\n"
+	var again bytes.Buffer
+	if err := Process(&again, strings.NewReader(out.String()), WithFetcher(files), WithSourceMap(true)); err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Replace(want, "package main", "package foo", 1); again.String() != want {
+		t.Errorf("expected output %q; got %q", want, again.String())
+	}
+
+	err := Process(ioutil.Discard, strings.NewReader("[embedmd]:# (code.go)\n<!-- embedmd:begin code.go -->\n"), WithFetcher(files))
+	if want := "2: unbalanced source map comment"; err == nil || err.Error() != want {
+		t.Errorf("expected error %q; got %v", want, err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n"}
+	tc := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "stale embed",
+			in:   "# Title\r\n\r\nSome *prose*.\r\n[embedmd]:# (code.go)\n```go\npackage old\n```\nMore prose.",
+			out:  "# Title\r\n\r\nSome *prose*.\r\n[embedmd]:# (code.go)\n```go\npackage main\n```\nMore prose.",
+		},
+		{
+			name: "other code blocks",
+			in:   "```sh\r\n$ go  run .\r\n```\r\n\r\n[embedmd]:# (code.go)\n```go\npackage main\n```\n",
+			out:  "```sh\r\n$ go  run .\r\n```\r\n\r\n[embedmd]:# (code.go)\n```go\npackage main\n```\n",
+		},
+		{
+			name: "missing embed",
+			in:   "## Heading  \n[embedmd]:# (code.go)\n\n  indented text\t\n",
+			out:  "## Heading  \n[embedmd]:# (code.go)\n```go\npackage main\n```\n\n  indented text\t\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Update([]byte(tt.in), WithFetcher(files))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.out {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, tt.out, got)
+			}
+		})
+	}
+}
+
+func TestIncludeGuard(t *testing.T) {
+	dir, err := ioutil.TempDir("", "embedmd-include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.md", "[embedmd]:# (docs/b.md raw)\n")
+	write("docs/b.md", "B embeds A:\n\n[embedmd]:# (../a.md raw)\n")
+	write("docs/c.md", "C embeds code:\n[embedmd]:# (code.go)\n")
+	write("docs/code.go", "package main\n")
+
+	a, err := ioutil.ReadFile(filepath.Join(dir, "a.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Process(ioutil.Discard, bytes.NewReader(a), WithBaseDir(dir), WithIncludeGuard(filepath.Join(dir, "a.md")))
+	if want := "embed cycle detected: " + filepath.Join(dir, "a.md") + " -> docs/b.md -> ../a.md"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error containing %q; got %v", want, err)
+	}
+
+	// embedding a document that embeds other files, relative to it, without
+	// their commands.
+	in := "[embedmd]:# (docs/c.md raw)\n"
+	want := in + "<!-- embedmd:raw -->\nC embeds code:\n```go\npackage main\n```\n<!-- embedmd:endraw -->\n"
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithBaseDir(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+	var again bytes.Buffer
+	if err := Process(&again, &out, WithBaseDir(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != want {
+		t.Errorf("expected output of the second run %q; got %q", want, again.String())
+	}
+}
+
+func TestWholeLines(t *testing.T) {
+	src := []byte("a := 1 // START\nb := 2\na := 1 // START\nc := 3\n")
+	// the second occurrence of the text, as extracted.
+	i := bytes.LastIndex(src, []byte("1 // START"))
+	if got, want := string(wholeLines(src, src[i:i+len("1 // START")])), "a := 1 // START\n"; got != want {
+		t.Errorf("expected %q; got %q", want, got)
+	}
+	if got, want := string(wholeLines(src, src[i-7:i+1])), "b := 2\na := 1 // START\n"; got != want {
+		t.Errorf("expected %q; got %q", want, got)
+	}
+}
+
+func TestContext(t *testing.T) {
+	files := fakeFetcher{
+		"code.go": "package main\n\nimport \"fmt\"\n\nfunc main() {\n\t// START a\n\tfmt.Println(1)\n\t// END a\n\treturn\n}\n\nfunc f() {}\n",
+		"top.go":  "// START a\nvar a = 1\n// END a\nvar b = 2\n",
+		"dup.go":  "one\na := 1\ntwo\na := 1\nthree\n",
+	}
+	tc := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "sample",
+			in:   "[embedmd]:# (code.go go a context=2)\n",
+			out:  "```go\n...\n\nfunc main() {\n\tfmt.Println(1)\n\treturn\n}\n...\n```\n",
+		},
+		{
+			name: "clamped at the start",
+			in:   "[embedmd]:# (top.go go a context=2)\n",
+			out:  "```go\nvar a = 1\nvar b = 2\n```\n",
+		},
+		{
+			name: "clamped at the end",
+			in:   "[embedmd]:# (code.go /func f/ $ context=2)\n",
+			out:  "```go\n...\n}\n\nfunc f() {}\n```\n",
+		},
+		{
+			name: "text found earlier",
+			in:   "[embedmd]:# (dup.go go /a := 1/[2] context=1)\n",
+			out:  "```go\n...\ntwo\na := 1\nthree\n```\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in), WithFetcher(files)); err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestReproducible(t *testing.T) {
+	dir, err := ioutil.TempDir("", "embedmd-reproducible")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "code.go"), []byte("package main\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	in := "# Title\r\n\r\n[embedmd]:# (code.go)\r\n"
+	want := "# Title\n\n[embedmd]:# (code.go)\n```go\npackage main\n```\n"
+	opts := []Option{
+		WithBaseDir(dir),
+		WithReproducible(true),
+		WithTimestampFooter(true),
+		WithSourceTimestamp(true),
+		WithReplacements(map[string]string{"package": "package", "main": "main"}),
+	}
+
+	var outputs []string
+	for i := 0; i < 2; i++ {
+		var out bytes.Buffer
+		if err := Process(&out, strings.NewReader(in), opts...); err != nil {
+			t.Fatal(err)
+		}
+		updated, err := Update([]byte(in), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, out.String(), string(updated))
+	}
+	for i, got := range outputs {
+		if got != want {
+			t.Errorf("output %d: expected %q; got %q", i, want, got)
+		}
+	}
+}
+
+func TestProcessDataURI(t *testing.T) {
+	in := "[embedmd]:# (data:text/plain;base64,cGFja2FnZSBtYWluCg== lang=go)\n"
+	want := in + "```go\npackage main\n```\n"
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+}
+
+func TestOnResolve(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n", "sub/data.csv": "a,b\n"}
+	in := "# Title\n\n[embedmd]:# (code.go)\n\n[embedmd]:# (sub/data.csv table)\n\n[embedmd]:# (https://example.com/x.go lang=go)\n"
+	files["https://example.com/x.go"] = "package x\n"
+
+	var got []string
+	var out, plain bytes.Buffer
+	err := Process(&out, strings.NewReader(in), WithFetcher(files), WithBaseDir("docs"), WithOnResolve(func(cmd *Command, size int) {
+		got = append(got, fmt.Sprintf("%d %s %s %d", cmd.Line, filepath.ToSlash(cmd.Path), cmd.Lang, size))
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"3 docs/code.go go 13", "5 docs/sub/data.csv csv 4", "7 https://example.com/x.go go 10"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected resolved commands %q; got %q", want, got)
+	}
+
+	if err := Process(&plain, strings.NewReader(in), WithFetcher(files), WithBaseDir("docs")); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != plain.String() {
+		t.Errorf("expected the same output as without callback %q; got %q", plain.String(), out.String())
+	}
+}
+
+func TestGoMod(t *testing.T) {
+	files := fakeFetcher{"go.mod": "module example.com/foo\n\ngo 1.21\n\nrequire (\n\tgithub.com/foo/bar v1.2.3\n\tgolang.org/x/mod v0.14.0 // indirect\n)\n"}
+	tc := []struct {
+		name string
+		opts []Option
+		in   string
+		out  string
+		err  string
+	}{
+		{name: "go version", in: "Requires Go\n[embedmd]:# (go.mod gomod)\nor later.\n", out: "Requires Go\n[embedmd]:# (go.mod gomod)\n1.21\nor later.\n"},
+		{
+			name: "source map",
+			opts: []Option{WithSourceMap(true)},
+			in:   "[embedmd]:# (go.mod gomod)\ntext\n",
+			out:  "[embedmd]:# (go.mod gomod)\n<!-- embedmd:begin go.mod gomod -->\n1.21\n<!-- embedmd:end -->\ntext\n",
+		},
+		{
+			name: "missing file",
+			opts: []Option{WithMissingFilePolicy(MissingFileWarn), WithLogger(log.New(ioutil.Discard, "", 0))},
+			in:   "[embedmd]:# (other/go.mod gomod)\ntext\n",
+			out:  "[embedmd]:# (other/go.mod gomod)\n<!-- missing: other/go.mod -->\ntext\n",
+		},
+		{name: "require", in: "[embedmd]:# (go.mod gomod require=github.com/foo/bar)\n", out: "[embedmd]:# (go.mod gomod require=github.com/foo/bar)\nv1.2.3\n"},
+		{name: "updated version", in: "[embedmd]:# (go.mod gomod require=golang.org/x/mod)\nv0.13.0\ntext\n", out: "[embedmd]:# (go.mod gomod require=golang.org/x/mod)\nv0.14.0\ntext\n"},
+		{name: "missing require", in: "[embedmd]:# (go.mod gomod require=github.com/other)\n", err: "1: could not extract content from go.mod: github.com/other is not required"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append(tt.opts, WithFetcher(files))
+			var out bytes.Buffer
+			err := Process(&out, strings.NewReader(tt.in), opts...)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.out {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, tt.out, out.String())
+			}
+
+			// processing the output again replaces what was embedded.
+			var again bytes.Buffer
+			if err := Process(&again, strings.NewReader(out.String()), opts...); err != nil {
+				t.Fatal(err)
+			}
+			if again.String() != tt.out {
+				t.Errorf("case [%s]: expected stable output %q; got %q", tt.name, tt.out, again.String())
+			}
+		})
+	}
+}
+
+func TestCollapsible(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n"}
+	tc := []struct {
+		name string
+		opts []Option
+		in   string
+		out  string
+	}{
+		{
+			name: "option",
+			opts: []Option{WithCollapsible("Show code")},
+			in:   "[embedmd]:# (code.go)\n",
+			out:  "<details><summary>Show code</summary>\n\n```go\npackage main\n```\n\n</details>\n",
+		},
+		{
+			name: "argument",
+			opts: []Option{WithCollapsible("Show code")},
+			in:   "[embedmd]:# (code.go collapse=\"main <package>\")\n",
+			out:  "<details><summary>main &lt;package&gt;</summary>\n\n```go\npackage main\n```\n\n</details>\n",
+		},
+		{
+			name: "argument without option",
+			in:   "[embedmd]:# (code.go collapse=Code)\n",
+			out:  "<details><summary>Code</summary>\n\n```go\npackage main\n```\n\n</details>\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append(tt.opts, WithFetcher(files))
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in), opts...); err != nil {
+				t.Fatal(err)
+			}
+			want := tt.in + tt.out
+			if out.String() != want {
+				t.Fatalf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+
+			// processing the output again replaces the whole details element.
+			var again bytes.Buffer
+			if err := Process(&again, strings.NewReader(out.String()+"text\n"), opts...); err != nil {
+				t.Fatal(err)
+			}
+			if again.String() != want+"text\n" {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want+"text\n", again.String())
+			}
+		})
+	}
+}
+
+func TestDetailsAfterCommand(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n"}
+	details := "<details><summary>Notes</summary>\n\nMine.\n\n</details>\n"
+	in := "[embedmd]:# (code.go)\n" + details
+	want := "[embedmd]:# (code.go)\n```go\npackage main\n```\n" + details
+	for i := 0; i < 2; i++ {
+		var out bytes.Buffer
+		if err := Process(&out, strings.NewReader(in), WithFetcher(files)); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Fatalf("run %d: expected the details element following the command to be kept in %q; got %q", i+1, want, out.String())
+		}
+		in = out.String()
+	}
+}
+
+func TestExtractNthMatch(t *testing.T) {
+	files := fakeFetcher{"code.go": "func a() {}\nfunc b() {}\nfunc c() {}\n", "vars.go": "var (\n\tx, y = 1, 2\n\tz = 3\n)\n"}
+	tc := []struct {
+		name string
+		in   string
+		out  string
+		err  string
+	}{
+		{name: "first", in: "[embedmd]:# (code.go /func .*\\n/[1])\n", out: "```go\nfunc a() {}\n```\n"},
+		{name: "later", in: "[embedmd]:# (code.go /func .*\\n/[3])\n", out: "```go\nfunc c() {}\n```\n"},
+		{name: "whole line", in: "[embedmd]:# (code.go /func/[2])\n", out: "```go\nfunc b() {}\n```\n"},
+		{name: "matches on the same line", in: "[embedmd]:# (vars.go /[0-9]/[2])\n", out: "```go\nz = 3\n```\n"},
+		{name: "out of range", in: "[embedmd]:# (code.go /func/[4])\n", err: "1: could not extract content from code.go: \"func\" only matches 3 times, cannot select match 4"},
+		{name: "no match", in: "[embedmd]:# (code.go /type/[2])\n", err: "1: could not extract content from code.go: could not match \"type\""},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Process(&out, strings.NewReader(tt.in), WithFetcher(files))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestColumns(t *testing.T) {
+	files := fakeFetcher{"data.txt": "ID   NAME      CITY\n001  Ada       London\n002  José      Málaga\n003  Bo\n"}
+	tc := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{name: "range", in: "[embedmd]:# (data.txt lang=text cols=6:15)\n", out: "```text\nNAME      \nAda       \nJosé      \nBo\n```\n"},
+		{name: "to the end", in: "[embedmd]:# (data.txt lang=text cols=16:)\n", out: "```text\nCITY\nLondon\nMálaga\n\n```\n"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in), WithFetcher(files)); err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestIndentIgnorePattern(t *testing.T) {
+	files := fakeFetcher{
+		"code.go": "func main() {\n\t// [START a]\n// Copyright banner.\n\tif ok {\n\t\treturn\n\t}\n\t// [END a]\n}\n",
+	}
+	in := "[embedmd]:# (code.go go a)\n"
+	tc := []struct {
+		name string
+		opts []Option
+		out  string
+	}{
+		{
+			name: "default",
+			out:  "```go\n// Copyright banner.\n\tif ok {\n\t\treturn\n\t}\n```\n",
+		},
+		{
+			name: "ignored comments",
+			opts: []Option{WithIndentIgnorePattern(regexp.MustCompile(`^//`))},
+			out:  "```go\n// Copyright banner.\nif ok {\n\treturn\n}\n```\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(in), append(tt.opts, WithFetcher(files))...); err != nil {
+				t.Fatal(err)
+			}
+			if want := in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestFileOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "embedmd-out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := "package main\n\nfunc main() {\n\t\tfmt.Println(\"hello\")\n\t}\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "src.go"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	in := "[embedmd]:# (src.go go /\\t\\tfmt/ /\\t}/ out=snippets/hello.go)\n"
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithBaseDir(dir), WithAllowFileOutput(true), WithLinePrefix("> ")); err != nil {
+		t.Fatal(err)
+	}
+	if want := in + "```go\n> \tfmt.Println(\"hello\")\n> }\n```\n"; out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "snippets", "hello.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\tfmt.Println(\"hello\")\n}\n"; string(b) != want {
+		t.Errorf("expected snippet file %q; got %q", want, b)
+	}
+
+	in = "[embedmd]:# (src.go go /func main/ out=snippets/main.txt)\n"
+	if err := Process(ioutil.Discard, strings.NewReader(in), WithBaseDir(dir), WithAllowFileOutput(true), WithEnsureTrailingNewline(false)); err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadFile(filepath.Join(dir, "snippets", "main.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "func main"; string(b) != want {
+		t.Errorf("expected snippet file %q; got %q", want, b)
+	}
+
+	err = Process(&out, strings.NewReader(in), WithBaseDir(dir))
+	if want := "1: file output is not enabled"; err == nil || err.Error() != want {
+		t.Errorf("expected error %q; got %v", want, err)
+	}
+}
+
+func TestLongLines(t *testing.T) {
+	long := strings.Repeat("var a=1;", 200*1024/8)
+	files := fakeFetcher{"app.min.js": long + "\n"}
+	in := "[embedmd]:# (app.min.js)\n"
+	want := in + "```js\n" + long + "\n```\n"
+
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithFetcher(files)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("expected the %d bytes line to be embedded; got %d bytes of output", len(long), out.Len())
+	}
+
+	// Processing the output again reads the long line from the markdown.
+	var again bytes.Buffer
+	if err := Process(&again, strings.NewReader(out.String()), WithFetcher(files)); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != want {
+		t.Errorf("expected processing to be stable; got %d bytes of output", again.Len())
+	}
+
+	err := Process(&out, strings.NewReader(in), WithFetcher(files), WithMaxLineLength(64*1024))
+	if want := "1: could not read app.min.js: bufio.Scanner: token too long"; err == nil || err.Error() != want {
+		t.Errorf("expected error %q; got %v", want, err)
+	}
+}
+
+func TestIndentAnnotation(t *testing.T) {
+	files := fakeFetcher{
+		"tabs.go":   "func main() {\n\tif ok {\n\t\treturn\n\t}\n}\n",
+		"spaces.py": "def f():\n    return 1\n",
+		"mixed.c":   "int f() {\n\treturn 1;\n        }\n",
+		"flat.txt":  "a\n\nb\n",
+	}
+	tc := []struct {
+		name string
+		in   string
+		opts []Option
+		out  string
+	}{
+		{name: "tabs", in: "[embedmd]:# (tabs.go)\n", out: "```go {indent=tabs}\nfunc main() {\n\tif ok {\n\t\treturn\n\t}\n}\n```\n"},
+		{name: "spaces", in: "[embedmd]:# (spaces.py lang=python)\n", out: "```python {indent=spaces}\ndef f():\n    return 1\n```\n"},
+		{name: "mixed", in: "[embedmd]:# (mixed.c)\n", out: "```c {indent=mixed}\nint f() {\n\treturn 1;\n        }\n```\n"},
+		{name: "not indented", in: "[embedmd]:# (flat.txt)\n", out: "```txt\na\n\nb\n```\n"},
+		{name: "tabs expanded", in: "[embedmd]:# (tabs.go)\n", opts: []Option{WithTabWidth(2)}, out: "```go {indent=spaces}\nfunc main() {\n  if ok {\n    return\n  }\n}\n```\n"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in), append(tt.opts, WithFetcher(files), WithIndentAnnotation(true))...); err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestBlockSummary(t *testing.T) {
+	files := fakeFetcher{
+		"code.go": "package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n",
+		"one.txt": "a",
+	}
+	tc := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "lines and bytes",
+			in:   "[embedmd]:# (code.go)\n",
+			out:  "```go\npackage main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n```\n<!-- 5 lines, 45 bytes -->\n",
+		},
+		{
+			name: "singular",
+			in:   "[embedmd]:# (one.txt)\n",
+			out:  "```txt\na\n```\n<!-- 1 line, 2 bytes -->\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in+"\ntext\n"), WithFetcher(files), WithBlockSummary(true)); err != nil {
+				t.Fatal(err)
+			}
+			want := tt.in + tt.out + "\ntext\n"
+			if out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+
+			// The comment is replaced when processing the output again.
+			var again bytes.Buffer
+			if err := Process(&again, strings.NewReader(out.String()), WithFetcher(files), WithBlockSummary(true)); err != nil {
+				t.Fatal(err)
+			}
+			if again.String() != want {
+				t.Errorf("case [%s]: expected output %q when processing again; got %q", tt.name, want, again.String())
+			}
+		})
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "embedmd-depth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// doc0.md embeds doc1.md, which embeds doc2.md, and so on up to doc4.md.
+	for i := 0; i < 5; i++ {
+		content := fmt.Sprintf("doc %d\n", i)
+		if i < 4 {
+			content += fmt.Sprintf("[embedmd]:# (doc%d.md raw)\n", i+1)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("doc%d.md", i)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	in := "[embedmd]:# (doc0.md raw)\n"
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithBaseDir(dir), WithMaxDepth(5)); err != nil {
+		t.Fatal(err)
+	}
+	want := in + "<!-- embedmd:raw -->\ndoc 0\ndoc 1\ndoc 2\ndoc 3\ndoc 4\n<!-- embedmd:endraw -->\n"
+	if out.String() != want {
+		t.Errorf("expected the whole chain to be embedded as %q; got %q", want, out.String())
+	}
+	var again bytes.Buffer
+	if err := Process(&again, &out, WithBaseDir(dir), WithMaxDepth(5)); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != want {
+		t.Errorf("expected output of the second run %q; got %q", want, again.String())
+	}
+
+	err = Process(ioutil.Discard, strings.NewReader(in), WithBaseDir(dir), WithMaxDepth(3))
+	if want := "could not embed doc3.md: maximum embedding depth of 3 exceeded"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error containing %q; got %v", want, err)
+	}
+}
+
+func TestRedactPatterns(t *testing.T) {
+	files := fakeFetcher{
+		"app.env": "USER=admin\npassword=hunter2\nAPI_TOKEN=abc123\nToken=\n# secret: kept in the vault\n",
+	}
+	in := "[embedmd]:# (app.env lang=sh)\n"
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithFetcher(files), WithRedactPatterns([]string{"password=", "token=", "secret:"})); err != nil {
+		t.Fatal(err)
+	}
+	want := in + "```sh\nUSER=admin\npassword=***\nAPI_TOKEN=***\nToken=\n# secret: ***\n```\n"
+	if out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n\nfunc main() {\n\tprintln(1)\n\tprintln(2)\n}\n"}
+	tc := []struct {
+		name string
+		in   string
+		out  string
+		err  string
+	}{
+		{
+			name: "lines and ranges",
+			in:   "[embedmd]:# (code.go /func main/ $ highlight=1,3-4)\n",
+			out:  "```go {1,3-4}\nfunc main() {\n\tprintln(1)\n\tprintln(2)\n}\n```\n",
+		},
+		{
+			name: "out of range",
+			in:   "[embedmd]:# (code.go /func main/ $ highlight=2-5)\n",
+			err:  "1: cannot highlight line 5 of code.go, only 4 lines are embedded",
+		},
+		{
+			name: "raw",
+			in:   "[embedmd]:# (code.go raw highlight=1)\n",
+			err:  "1: highlight cannot be used without a code fence",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Process(&out, strings.NewReader(tt.in), WithFetcher(files))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestStripLicenseHeader(t *testing.T) {
+	license := "// Copyright 2016 Google Inc. All rights reserved.\n// Licensed under the Apache License, Version 2.0.\n\n"
+	tc := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{name: "line comments", in: license + "package main\n", out: "package main\n"},
+		{name: "block comment", in: "/*\n * Copyright 2016.\n */\n\n\n#include <stdio.h>\n", out: "#include <stdio.h>\n"},
+		{name: "hash comments after shebang", in: "#!/bin/sh\n# Copyright 2016.\n#\n# MIT License.\n\necho hi\n", out: "#!/bin/sh\necho hi\n"},
+		{name: "no header", in: "package main\n\n// main runs.\nfunc main() {}\n", out: "package main\n\n// main runs.\nfunc main() {}\n"},
+		{name: "doc comment", in: "// Package main runs.\npackage main\n", out: "// Package main runs.\npackage main\n"},
+		{name: "preprocessor", in: "#include <stdio.h>\n\nint x;\n", out: "#include <stdio.h>\n\nint x;\n"},
+		{name: "only comments", in: "// just a comment\n", out: "// just a comment\n"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripLicenseHeader([]byte(tt.in)); string(got) != tt.out {
+				t.Errorf("case [%s]: expected %q; got %q", tt.name, tt.out, got)
+			}
+		})
+	}
+
+	// the header is only removed when embedding the whole file.
+	files := fakeFetcher{"code.go": license + "package main\n\n// [START a]\nfunc a() {}\n// [END a]\n"}
+	in := "[embedmd]:# (code.go)\n\n[embedmd]:# (code.go /Copyright/ /Licensed.*/)\n"
+	want := "[embedmd]:# (code.go)\n```go\npackage main\n\n// [START a]\nfunc a() {}\n// [END a]\n```\n\n" +
+		"[embedmd]:# (code.go /Copyright/ /Licensed.*/)\n```go\nCopyright 2016 Google Inc. All rights reserved.\n// Licensed under the Apache License, Version 2.0.\n```\n"
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithFetcher(files), WithStripLicenseHeader(true)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+}
+
+func TestLinkedCaption(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "package remote")
+	}))
+	defer srv.Close()
+	files := fakeFetcher{"docs/code.go": "package main\n", `docs\win.go`: "package win\n"}
+
+	tc := []struct {
+		name string
+		in   string
+		out  string
+		opts []Option
+	}{
+		{
+			name: "URL",
+			in:   "[embedmd]:# (" + srv.URL + "/code.go)\n",
+			out:  "[" + srv.URL + "/code.go](" + srv.URL + "/code.go)\n```go\npackage remote\n```\n",
+		},
+		{
+			name: "local file",
+			in:   "[embedmd]:# (docs/code.go)\n",
+			out:  "[docs/code.go](docs/code.go)\n```go\npackage main\n```\n",
+			opts: []Option{WithFetcher(files)},
+		},
+		{
+			name: "windows separators",
+			in:   "[embedmd]:# (docs\\win.go)\n",
+			out:  "[docs\\win.go](docs/win.go)\n```go\npackage win\n```\n",
+			opts: []Option{WithFetcher(files)},
+		},
+		{
+			name: "data URI",
+			in:   "[embedmd]:# (data:,hello lang=text)\n",
+			out:  "```text\nhello\n```\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append(tt.opts, WithLinkedCaption(true))
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in+"\ntext\n"), opts...); err != nil {
+				t.Fatal(err)
+			}
+			want := tt.in + tt.out + "\ntext\n"
+			if out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+
+			// The caption is replaced when processing the output again.
+			var again bytes.Buffer
+			if err := Process(&again, strings.NewReader(out.String()), opts...); err != nil {
+				t.Fatal(err)
+			}
+			if again.String() != want {
+				t.Errorf("case [%s]: expected output %q when processing again; got %q", tt.name, want, again.String())
+			}
+		})
+	}
+}
+
+func TestAllowEmptyMatch(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n\n// START main\nfunc main() {}\n// END main\n"}
+	in := "[embedmd]:# (code.go go todo)\n"
+
+	tc := []struct {
+		name  string
+		allow bool
+		out   string
+		log   string
+		err   string
+	}{
+		{
+			name: "strict",
+			err:  `1: could not extract content from code.go: could not match "START todo"`,
+		},
+		{
+			name:  "allow empty",
+			allow: true,
+			out:   in + "```go\n```\n",
+			log:   "1: code.go: could not match \"START todo\", embedding empty content\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs, out bytes.Buffer
+			err := Process(&out, strings.NewReader(in), WithFetcher(files), WithLogger(log.New(&logs, "", 0)), WithAllowEmptyMatch(tt.allow))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.out {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, tt.out, out.String())
+			}
+			if logs.String() != tt.log {
+				t.Errorf("case [%s]: expected warning %q; got %q", tt.name, tt.log, logs.String())
+			}
+		})
+	}
+}
+
+func TestOutputNewline(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n\nfunc main() {}\n"}
+	in := "# Title\n\n[embedmd]:# (code.go)\n\nText.\n"
+
+	tc := []struct {
+		name  string
+		style string
+		opts  []Option
+		out   string
+		err   string
+	}{
+		{
+			name: "default",
+			out:  "# Title\n\n[embedmd]:# (code.go)\n```go\npackage main\n\nfunc main() {}\n```\n\nText.\n",
+		},
+		{
+			name:  "lf",
+			style: "lf",
+			out:   "# Title\n\n[embedmd]:# (code.go)\n```go\npackage main\n\nfunc main() {}\n```\n\nText.\n",
+		},
+		{
+			name:  "crlf",
+			style: "crlf",
+			out:   "# Title\n\n[embedmd]:# (code.go)\n```go\r\npackage main\r\n\r\nfunc main() {}\r\n```\r\n\nText.\n",
+		},
+		{
+			name:  "crlf reproducible",
+			style: "crlf",
+			opts:  []Option{WithReproducible(true)},
+			out:   "# Title\n\n[embedmd]:# (code.go)\n```go\npackage main\n\nfunc main() {}\n```\n\nText.\n",
+		},
+		{
+			name:  "unknown",
+			style: "cr",
+			err:   `3: unknown newline style "cr", want lf or crlf`,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Process(&out, strings.NewReader(in), append(tt.opts, WithFetcher(files), WithOutputNewline(tt.style))...)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.out {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, tt.out, out.String())
+			}
+
+			// The generated content is replaced when processing the output again.
+			var again bytes.Buffer
+			if err := Process(&again, strings.NewReader(out.String()), append(tt.opts, WithFetcher(files), WithOutputNewline(tt.style))...); err != nil {
+				t.Fatal(err)
+			}
+			if again.String() != tt.out {
+				t.Errorf("case [%s]: expected output %q when processing again; got %q", tt.name, tt.out, again.String())
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n\nfunc main() {}\n"}
+
+	tc := []struct {
+		name    string
+		in      string
+		opts    []Option
+		diff    string
+		changed bool
+	}{
+		{
+			name:    "stale block",
+			in:      "# Title\n\n[embedmd]:# (code.go)\n```go\npackage main\n```\n",
+			diff:    "--- original\n+++ processed\n@@ -3,5 +3,7 @@\n [embedmd]:# (code.go)\n ```go\n package main\n+\n+func main() {}\n ```\n \n",
+			changed: true,
+		},
+		{
+			name:    "named document",
+			in:      "[embedmd]:# (code.go)\n```go\npackage main\n```\n",
+			opts:    []Option{WithIncludeGuard("docs/README.md")},
+			diff:    "--- a/docs/README.md\n+++ b/docs/README.md\n@@ -1,5 +1,7 @@\n [embedmd]:# (code.go)\n ```go\n package main\n+\n+func main() {}\n ```\n \n",
+			changed: true,
+		},
+		{
+			name: "up to date",
+			in:   "# Title\n\n[embedmd]:# (code.go)\n```go\npackage main\n\nfunc main() {}\n```\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			changed, err := Diff(&out, strings.NewReader(tt.in), append(tt.opts, WithFetcher(files))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.changed {
+				t.Errorf("case [%s]: expected changed to be %v; got %v", tt.name, tt.changed, changed)
+			}
+			if out.String() != tt.diff {
+				t.Errorf("case [%s]: expected diff %q; got %q", tt.name, tt.diff, out.String())
+			}
+		})
+	}
+}
+
+func TestDiffFileOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "embedmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := fakeFetcher{"code.go": "package main\n"}
+	in := "[embedmd]:# (code.go lang=go out=snippets/code.go)\n"
+	if _, err := Diff(ioutil.Discard, strings.NewReader(in), WithFetcher(files), WithBaseDir(dir), WithAllowFileOutput(true)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "snippets", "code.go")); !os.IsNotExist(err) {
+		t.Errorf("expected no file to be written while diffing; got %v", err)
+	}
+}
+
+func TestPathRewrite(t *testing.T) {
+	files := fakeFetcher{"internal/acme-secret/code.go": "package main\n"}
+	rewrite := func(p string) string {
+		return strings.Replace(p, "internal/acme-secret/", "https://example.com/project/", 1)
+	}
+
+	tc := []struct {
+		name string
+		opts []Option
+		out  string
+	}{
+		{
+			name: "caption",
+			opts: []Option{WithLinkedCaption(true)},
+			out:  "[https://example.com/project/code.go](https://example.com/project/code.go)\n```go\npackage main\n```\n",
+		},
+		{
+			name: "source map",
+			opts: []Option{WithSourceMap(true)},
+			out:  "<!-- embedmd:begin https://example.com/project/code.go -->\n```go\npackage main\n```\n<!-- embedmd:end -->\n",
+		},
+	}
+
+	in := "[embedmd]:# (internal/acme-secret/code.go)\n"
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append(tt.opts, WithFetcher(files), WithPathRewrite(rewrite))
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(in), opts...); err != nil {
+				t.Fatal(err)
+			}
+			if want := in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+
+			// The rewritten output is replaced when processing it again.
+			var again bytes.Buffer
+			if err := Process(&again, strings.NewReader(out.String()), opts...); err != nil {
+				t.Fatal(err)
+			}
+			if again.String() != out.String() {
+				t.Errorf("case [%s]: expected output %q when processing again; got %q", tt.name, out.String(), again.String())
+			}
+		})
+	}
+}
+
+func TestPreview(t *testing.T) {
+	files := fakeFetcher{
+		"long.txt":  "1\n2\n3\n4\n5\n",
+		"short.txt": "1\n2\n",
+	}
+	const tmpl = "https://example.com/blob/main/{path}"
+
+	tc := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "long file",
+			in:   "[embedmd]:# (long.txt)\n",
+			out:  "```txt\n1\n2\n3\n```\n[Show all 5 lines](https://example.com/blob/main/long.txt)\n",
+		},
+		{
+			name: "short file",
+			in:   "[embedmd]:# (short.txt)\n",
+			out:  "```txt\n1\n2\n```\n",
+		},
+		{
+			name: "head",
+			in:   "[embedmd]:# (long.txt head=4)\n",
+			out:  "```txt\n1\n2\n3\n4\n...\n```\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Option{WithFetcher(files), WithPreview(3, tmpl)}
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in+"\ntext\n"), opts...); err != nil {
+				t.Fatal(err)
+			}
+			want := tt.in + tt.out + "\ntext\n"
+			if out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+
+			// The link is replaced when processing the output again.
+			var again bytes.Buffer
+			if err := Process(&again, strings.NewReader(out.String()), append(opts, WithBlockSummary(true))...); err != nil {
+				t.Fatal(err)
+			}
+			var twice bytes.Buffer
+			if err := Process(&twice, strings.NewReader(again.String()), append(opts, WithBlockSummary(true))...); err != nil {
+				t.Fatal(err)
+			}
+			if again.String() != twice.String() {
+				t.Errorf("case [%s]: expected output %q when processing again; got %q", tt.name, again.String(), twice.String())
+			}
+			if got, want := strings.Count(again.String(), "[Show all"), strings.Count(out.String(), "[Show all"); got != want {
+				t.Errorf("case [%s]: expected %d links when processing again; got %d", tt.name, want, got)
+			}
+		})
+	}
+}
+
+func TestPreviewPathRewrite(t *testing.T) {
+	files := fakeFetcher{"internal/long.txt": "1\n2\n3\n4\n5\n"}
+	rewrite := func(p string) string { return strings.TrimPrefix(p, "internal/") }
+	in := "[embedmd]:# (internal/long.txt)\n"
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithFetcher(files), WithPathRewrite(rewrite), WithPreview(3, "https://example.com/blob/main/{path}")); err != nil {
+		t.Fatal(err)
+	}
+	if want := "[Show all 5 lines](https://example.com/blob/main/long.txt)\n"; !strings.HasSuffix(out.String(), want) {
+		t.Errorf("expected output ending with %q; got %q", want, out.String())
+	}
+}
+
+func TestSampleSeparator(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n\n// START setup\nsetup()\n// END setup\n\nrun()\n\n// START teardown\nteardown()\n// END teardown\n"}
+	in := "[embedmd]:# (code.go go setup,teardown)\n"
+
+	tc := []struct {
+		name string
+		opts []Option
+		out  string
+	}{
+		{
+			name: "blank line",
+			out:  "```go\nsetup()\n\nteardown()\n```\n",
+		},
+		{
+			name: "elision",
+			opts: []Option{WithSampleSeparator("// ...")},
+			out:  "```go\nsetup()\n// ...\nteardown()\n```\n",
+		},
+		{
+			name: "markers kept",
+			opts: []Option{WithSampleSeparator("// ..."), WithKeepRegionMarkers(true)},
+			out:  "```go\n// START setup\nsetup()\n// END setup\n// ...\n// START teardown\nteardown()\n// END teardown\n```\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(in), append(tt.opts, WithFetcher(files))...); err != nil {
+				t.Fatal(err)
+			}
+			if want := in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestDedupAdjacentBlocks(t *testing.T) {
+	files := fakeFetcher{"a.go": "package a\n", "b.go": "package b\n"}
+	tc := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "adjacent duplicates",
+			in:   "[embedmd]:# (a.go)\n\n[embedmd]:# (a.go)\n",
+			out:  "[embedmd]:# (a.go)\n```go\npackage a\n```\n\n[embedmd]:# (a.go)\n",
+		},
+		{
+			name: "different blocks",
+			in:   "[embedmd]:# (a.go)\n\n[embedmd]:# (b.go)\n",
+			out:  "[embedmd]:# (a.go)\n```go\npackage a\n```\n\n[embedmd]:# (b.go)\n```go\npackage b\n```\n",
+		},
+		{
+			name: "text in between",
+			in:   "[embedmd]:# (a.go)\n\nAgain:\n\n[embedmd]:# (a.go)\n",
+			out:  "[embedmd]:# (a.go)\n```go\npackage a\n```\n\nAgain:\n\n[embedmd]:# (a.go)\n```go\npackage a\n```\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in), WithFetcher(files), WithDedupAdjacentBlocks(true)); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.out {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, tt.out, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// blame returns the abbreviated hash of the commit that last changed each line
+// of the content b extracted from src, read from the local file at path.
+func (e *embedder) blame(path string, src, b []byte) ([]string, error) {
+	if !e.gitBlame {
+		return nil, errors.New("blame is not enabled")
+	}
+	if !isLocalPath(path) {
+		return nil, errors.New("blame requires a local file")
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+	i := offsetIn(src, b)
+	if i < 0 {
+		return nil, errors.New("could not locate the embedded lines")
+	}
+	first := bytes.Count(src[:i], []byte("\n")) + 1
+	last := first + bytes.Count(bytes.TrimSuffix(b, []byte("\n")), []byte("\n"))
+	return gitBlame(localPath(e.baseDir, path), first, last)
+}
+
+// addedLines returns the lines added to the local file at path since the git
+// revision ref.
+func (e *embedder) addedLines(path, ref string) ([]byte, error) {
+	if !e.gitDiff {
+		return nil, errors.New("since is not enabled")
+	}
+	if !isLocalPath(path) {
+		return nil, errors.New("since requires a local file")
+	}
+	return gitAddedLines(localPath(e.baseDir, path), ref)
+}
+
+// gitAddedLines runs git diff between the revision ref and the working tree
+// version of the file at path and returns the added lines.
+func gitAddedLines(path, ref string) ([]byte, error) {
+	if strings.HasPrefix(ref, "-") {
+		return nil, fmt.Errorf("bad git revision %s", ref)
+	}
+	cmd := exec.Command("git", "diff", "--no-color", "--no-ext-diff", "-U0", ref, "--", filepath.Base(path))
+	cmd.Dir = filepath.Dir(path)
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+			return nil, fmt.Errorf("git diff: %s", bytes.TrimSpace(ee.Stderr))
+		}
+		return nil, err
+	}
+
+	// Added lines start with a plus sign, like the header naming the new
+	// version of the file, which comes before the first hunk.
+	var added bytes.Buffer
+	inHunk := false
+	s := newLineScanner(bytes.NewReader(out), 0)
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			inHunk = true
+		case inHunk && strings.HasPrefix(line, "+"):
+			added.WriteString(line[1:] + "\n")
+		}
+	}
+	return added.Bytes(), s.Err()
+}
+
+// checkTracked returns an error if path is a local file in a git work tree that
+// is not tracked by git. Other paths are accepted.
+func (e *embedder) checkTracked(path string) error {
+	if !isLocalPath(path) {
+		return nil
+	}
+	path = localPath(e.baseDir, path)
+	cmd := exec.Command("git", "rev-parse", "--is-inside-work-tree")
+	cmd.Dir = filepath.Dir(path)
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return nil // not in a git work tree.
+		}
+		return err
+	}
+	cmd = exec.Command("git", "ls-files", "--error-unmatch", "--", filepath.Base(path))
+	cmd.Dir = filepath.Dir(path)
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return errors.New("file is not tracked by git")
+		}
+		return err
+	}
+	return nil
+}
+
+// gitBlame runs git blame on the lines from first to last, both included, of
+// the file at path and returns the abbreviated commit hash of each of them.
+func gitBlame(path string, first, last int) ([]string, error) {
+	cmd := exec.Command("git", "blame", "--porcelain", "-L", fmt.Sprintf("%d,%d", first, last), "--", filepath.Base(path))
+	cmd.Dir = filepath.Dir(path)
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+			return nil, fmt.Errorf("git blame: %s", bytes.TrimSpace(ee.Stderr))
+		}
+		return nil, err
+	}
+
+	// In the porcelain format each line of the file is preceded by a header
+	// starting with the full commit hash, and followed by the commit details
+	// the first time the commit appears.
+	var hashes []string
+	var hash string
+	s := newLineScanner(bytes.NewReader(out), 0)
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "\t") {
+			hashes = append(hashes, hash)
+			continue
+		}
+		if f := strings.Fields(line); len(f) >= 3 && isCommitHash(f[0]) {
+			hash = f[0][:7]
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(hashes) != last-first+1 {
+		return nil, fmt.Errorf("git blame returned %d lines, expected %d", len(hashes), last-first+1)
+	}
+	return hashes, nil
+}
+
+// isCommitHash reports whether s is a full SHA-1 or SHA-256 commit hash.
+func isCommitHash(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
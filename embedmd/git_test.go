@@ -0,0 +1,186 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitBlame(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "embedmd-blame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=embedmd", "-c", "user.email=embedmd@example.com", "-c", "commit.gpgsign=false"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, "code.go"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	git("init", "-q")
+	write("package main\n\n// START a\nfunc a() {}\n// END a\n")
+	git("add", "code.go")
+	git("commit", "-q", "-m", "first")
+	first := git("rev-parse", "HEAD")[:7]
+	write("package main\n\n// START a\nfunc a() {}\n\nfunc b() {}\n// END a\n")
+	git("commit", "-q", "-a", "-m", "second")
+	second := git("rev-parse", "HEAD")[:7]
+
+	in := "[embedmd]:# (code.go go a blame)\n"
+	want := in + fmt.Sprintf("```go\n%s func a() {}\n%s\n%s func b() {}\n```\n", first, second, second)
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithBaseDir(dir), WithGitBlame(true)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+
+	// the same lines added earlier in the file are not the ones embedded.
+	write("func a() {}\n\nfunc b() {}\n\npackage main\n\n// START a\nfunc a() {}\n\nfunc b() {}\n// END a\n")
+	git("commit", "-q", "-a", "-m", "third")
+	between := `[embedmd]:# (code.go go begin="// START a" end="// END a" blame)` + "\n"
+	out.Reset()
+	if err := Process(&out, strings.NewReader(between), WithBaseDir(dir), WithGitBlame(true)); err != nil {
+		t.Fatal(err)
+	}
+	if want := between + strings.TrimPrefix(want, in); out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+
+	err = Process(&out, strings.NewReader(in), WithBaseDir(dir))
+	if want := "1: could not blame code.go: blame is not enabled"; err == nil || err.Error() != want {
+		t.Errorf("expected error %q; got %v", want, err)
+	}
+}
+
+func TestGitDiff(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "embedmd-diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=embedmd", "-c", "user.email=embedmd@example.com", "-c", "commit.gpgsign=false", "-c", "tag.gpgsign=false"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	write := func(content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, "CHANGES.md"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	git("init", "-q")
+	write("# Changes\n\n- Initial release.\n")
+	git("add", "CHANGES.md")
+	git("commit", "-q", "-m", "first")
+	git("tag", "v1.0")
+	write("# Changes\n\n- Add since.\n- Fix blame.\n\n- Initial release.\n+ Plus sign.\n")
+
+	in := "[embedmd]:# (CHANGES.md markdown since=v1.0)\n"
+	want := in + "```markdown\n- Add since.\n- Fix blame.\n\n+ Plus sign.\n```\n"
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithBaseDir(dir), WithGitDiff(true)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+
+	err = Process(&out, strings.NewReader(in), WithBaseDir(dir))
+	if want := "1: could not extract content from CHANGES.md: since is not enabled"; err == nil || err.Error() != want {
+		t.Errorf("expected error %q; got %v", want, err)
+	}
+	err = Process(&out, strings.NewReader("[embedmd]:# (data:,changes lang=text since=v1.0)\n"), WithBaseDir(dir), WithGitDiff(true))
+	if want := "1: could not extract content from data:,changes: since requires a local file"; err == nil || err.Error() != want {
+		t.Errorf("expected error %q; got %v", want, err)
+	}
+	err = Process(&out, strings.NewReader("[embedmd]:# (CHANGES.md since=v9.9)\n"), WithBaseDir(dir), WithGitDiff(true))
+	if want := "1: could not extract content from CHANGES.md: git diff: "; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("expected error starting with %q; got %v", want, err)
+	}
+}
+
+func TestRequireTracked(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "embedmd-tracked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=embedmd", "-c", "user.email=embedmd@example.com", "-c", "commit.gpgsign=false"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	for name, content := range map[string]string{"code.go": "package main\n", "gen.go": "package gen\n"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	git("add", "code.go")
+	git("commit", "-q", "-m", "first")
+
+	in := "[embedmd]:# (code.go)\n"
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithBaseDir(dir), WithRequireTracked(true)); err != nil {
+		t.Fatal(err)
+	}
+	if want := in + "```go\npackage main\n```\n"; out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+
+	in = "[embedmd]:# (gen.go)\n"
+	err = Process(&out, strings.NewReader(in), WithBaseDir(dir), WithRequireTracked(true))
+	if want := "1: could not embed gen.go: file is not tracked by git"; err == nil || err.Error() != want {
+		t.Errorf("expected error %q; got %v", want, err)
+	}
+	if err := Process(&out, strings.NewReader(in), WithBaseDir(dir)); err != nil {
+		t.Errorf("expected untracked files to be accepted by default; got %v", err)
+	}
+}
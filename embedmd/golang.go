@@ -0,0 +1,346 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// extractGoFunc returns the declaration, including its doc comment, of the Go
+// function or method with the given name in the source. Methods are named
+// after their receiver type, as in Type.Method. If signature is true, the
+// body of the function is omitted.
+func extractGoFunc(b []byte, name string, signature bool) ([]byte, error) {
+	fset, _, fn, err := findGoFunc(b, name)
+	if err != nil {
+		return nil, err
+	}
+	start, end := fn.Pos(), fn.End()
+	if fn.Doc != nil {
+		start = fn.Doc.Pos()
+	}
+	if signature {
+		end = fn.Type.End()
+	}
+	return b[fset.Position(start).Offset:fset.Position(end).Offset], nil
+}
+
+// findGoFunc parses the source and returns the declaration of the function or
+// method with the given name, as accepted by extractGoFunc.
+func findGoFunc(b []byte, name string) (*token.FileSet, *ast.File, *ast.FuncDecl, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", b, parser.ParseComments)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && funcName(fn) == name {
+			return fset, f, fn, nil
+		}
+	}
+	return nil, nil, nil, fmt.Errorf("could not find func %s", name)
+}
+
+// goFuncImports returns an import block listing the packages used by the
+// function with the given name, or by its signature only if signature is true.
+// It returns nil if the function uses no imported packages.
+func goFuncImports(b []byte, name string, signature bool) ([]byte, error) {
+	_, f, fn, err := findGoFunc(b, name)
+	if err != nil {
+		return nil, err
+	}
+	var node ast.Node = fn
+	if signature {
+		node = fn.Type
+	}
+	used := map[string]bool{}
+	ast.Inspect(node, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok && id.Obj == nil {
+				used[id.Name] = true
+			}
+		}
+		return true
+	})
+
+	var imports []string
+	for _, imp := range f.Imports {
+		p, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			return nil, err
+		}
+		pkg := importName(p)
+		if imp.Name != nil {
+			pkg = imp.Name.Name
+		}
+		if !used[pkg] {
+			continue
+		}
+		if imp.Name != nil {
+			imports = append(imports, imp.Name.Name+" "+imp.Path.Value)
+		} else {
+			imports = append(imports, imp.Path.Value)
+		}
+	}
+	if len(imports) == 0 {
+		return nil, nil
+	}
+	return []byte("import (\n\t" + strings.Join(imports, "\n\t") + "\n)\n\n"), nil
+}
+
+// importName returns the name a package imported without a name is assumed to
+// have, as its package clause cannot be read: the last element of its import
+// path, skipping a major version element like v2, without a go- prefix, and
+// up to the first character that cannot be part of an identifier, so that
+// gopkg.in/yaml.v2 is yaml.
+func importName(p string) string {
+	base := path.Base(p)
+	if strings.HasPrefix(base, "v") && len(base) > 1 && strings.Trim(base[1:], "0123456789") == "" {
+		if dir := path.Dir(p); dir != "." {
+			base = path.Base(dir)
+		}
+	}
+	base = strings.TrimPrefix(base, "go-")
+	if i := strings.IndexFunc(base, func(r rune) bool {
+		return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}); i >= 0 {
+		base = base[:i]
+	}
+	return base
+}
+
+// funcName returns the name of a function, or Type.Method for methods.
+func funcName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return fn.Name.Name
+	}
+	typ := fn.Recv.List[0].Type
+	for {
+		switch t := typ.(type) {
+		case *ast.StarExpr:
+			typ = t.X
+		case *ast.IndexExpr:
+			typ = t.X
+		case *ast.IndexListExpr:
+			typ = t.X
+		case *ast.Ident:
+			return t.Name + "." + fn.Name.Name
+		default:
+			return fn.Name.Name
+		}
+	}
+}
+
+var packageClauseRE = regexp.MustCompile(`(?m)^package [\pL_][\pL\pN_]*\s*(//.*)?$`)
+
+// verifyGo checks that Go code containing a package clause, and therefore
+// expected to be a complete file, can be parsed. Fragments are not checked.
+func verifyGo(b []byte) error {
+	if !packageClauseRE.Match(b) {
+		return nil
+	}
+	_, err := parser.ParseFile(token.NewFileSet(), "", b, 0)
+	return err
+}
+
+// goLiteralLines returns the indexes of the given lines of Go code that start
+// inside a raw string literal, whose leading tabs are part of the string.
+func goLiteralLines(code []string) map[int]bool {
+	src := []byte(strings.Join(code, "\n"))
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s scanner.Scanner
+	s.Init(file, src, func(token.Position, string) {}, 0)
+
+	lines := make(map[int]bool)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return lines
+		}
+		if tok != token.STRING || !strings.HasPrefix(lit, "`") {
+			continue
+		}
+		first := file.Line(pos) - 1
+		for i := 1; i <= strings.Count(lit, "\n"); i++ {
+			lines[first+i] = true
+		}
+	}
+}
+
+// trimDanglingToken removes a comma or an opening brace ending the last non
+// blank line of the given Go code, left there when a region ends in the middle
+// of an expression or a block. Lines ending with a comment are kept as is.
+func trimDanglingToken(code []string) []string {
+	for i := len(code) - 1; i >= 0; i-- {
+		line := strings.TrimRight(code[i], " \t")
+		if line == "" {
+			continue
+		}
+		if !strings.Contains(line, "//") && (strings.HasSuffix(line, ",") || strings.HasSuffix(line, "{")) {
+			code[i] = strings.TrimRight(line[:len(line)-1], " \t")
+		}
+		break
+	}
+	return code
+}
+
+// extractExported returns the exported declarations of the Go source, in order
+// and separated by blank lines. Function bodies are replaced with a // ...
+// comment, and the unexported specs of grouped declarations are skipped.
+func extractExported(b []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", b, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	offset := func(p token.Pos) int { return fset.Position(p).Offset }
+	// text returns the source of node, from the start of the line of its doc
+	// comment, if any, so grouped specs keep their indentation.
+	text := func(doc *ast.CommentGroup, node ast.Node) []byte {
+		start := offset(node.Pos())
+		if doc != nil {
+			start = offset(doc.Pos())
+		}
+		for start > 0 && (b[start-1] == ' ' || b[start-1] == '\t') {
+			start--
+		}
+		return b[start:offset(node.End())]
+	}
+
+	var decls [][]byte
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if !d.Name.IsExported() || !exportedRecv(d) {
+				continue
+			}
+			fn := text(d.Doc, d.Type)
+			if d.Body != nil {
+				fn = append(fn[:len(fn):len(fn)], " {\n\t// ...\n}"...)
+			}
+			decls = append(decls, fn)
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT {
+				continue
+			}
+			var specs [][]byte
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if s.Name.IsExported() {
+						specs = append(specs, text(s.Doc, s))
+					}
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						if name.IsExported() {
+							specs = append(specs, text(s.Doc, s))
+							break
+						}
+					}
+				}
+			}
+			switch {
+			case len(specs) == 0:
+			case len(specs) == len(d.Specs):
+				decls = append(decls, text(d.Doc, d))
+			default:
+				// Keep the doc comment and the opening parenthesis of the group.
+				group := append([]byte(nil), text(d.Doc, d)...)
+				group = group[:len(group)-(offset(d.End())-offset(d.Lparen)-1)]
+				for _, s := range specs {
+					group = append(append(group, '\n'), s...)
+				}
+				decls = append(decls, append(group, "\n)"...))
+			}
+		}
+	}
+	if len(decls) == 0 {
+		return nil, errors.New("could not find exported declarations")
+	}
+	return append(bytes.Join(decls, []byte("\n\n")), '\n'), nil
+}
+
+// extractGoDoc returns the text of the doc comment of the package clause of the
+// given Go source, without comment markers.
+func extractGoDoc(b []byte) ([]byte, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), "", b, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if f.Doc == nil {
+		return nil, errors.New("could not find package doc comment")
+	}
+	return []byte(f.Doc.Text()), nil
+}
+
+// exportedRecv reports whether the given function is not a method, or is the
+// method of an exported type.
+func exportedRecv(fn *ast.FuncDecl) bool {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return true
+	}
+	name := funcName(fn)
+	if i := strings.Index(name, "."); i >= 0 {
+		return ast.IsExported(name[:i])
+	}
+	return false
+}
+
+// hasPackageClause reports whether the first token of the given Go code, after
+// any comments, is the package keyword.
+func hasPackageClause(code []string) bool {
+	src := []byte(strings.Join(code, "\n"))
+	fset := token.NewFileSet()
+	var s scanner.Scanner
+	s.Init(fset.AddFile("", fset.Base(), len(src)), src, nil, 0)
+	_, tok, _ := s.Scan()
+	return tok == token.PACKAGE
+}
+
+// isGoDecls reports whether the given Go code is a list of top-level
+// declarations, which can follow a package clause, rather than statements.
+func isGoDecls(code []string) bool {
+	src := "package main\n" + strings.Join(code, "\n")
+	_, err := parser.ParseFile(token.NewFileSet(), "", src, parser.AllErrors)
+	return err == nil
+}
+
+// wrapMain wraps the given Go statements in the main function of a main
+// package, indenting them by a tab. Top-level declarations, such as functions
+// and types, only get the package clause.
+func wrapMain(code []string) []string {
+	if isGoDecls(code) {
+		return append([]string{"package main", ""}, code...)
+	}
+	wrapped := []string{"package main", "", "func main() {"}
+	for _, line := range code {
+		if line != "" {
+			line = "\t" + line
+		}
+		wrapped = append(wrapped, line)
+	}
+	return append(wrapped, "}")
+}
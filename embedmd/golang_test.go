@@ -0,0 +1,483 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const goContent = `package main
+
+import "fmt"
+
+// Hello greets the given name.
+func Hello(name string) (string, error) {
+	return fmt.Sprintf("hello, %s", name), nil
+}
+
+type T struct{}
+
+func (t *T) Greet(name string) {
+	fmt.Println(Hello(name))
+}
+
+type P[K comparable, V any] map[K]V
+
+func (p P[K, V]) Get(k K) V {
+	return p[k]
+}
+`
+
+func TestExtractGoFunc(t *testing.T) {
+	tc := []struct {
+		name      string
+		fn        string
+		signature bool
+		out       string
+		err       string
+	}{
+		{
+			name: "function",
+			fn:   "Hello",
+			out:  "// Hello greets the given name.\nfunc Hello(name string) (string, error) {\n\treturn fmt.Sprintf(\"hello, %s\", name), nil\n}",
+		},
+		{
+			name:      "function signature",
+			fn:        "Hello",
+			signature: true,
+			out:       "// Hello greets the given name.\nfunc Hello(name string) (string, error)",
+		},
+		{
+			name:      "method signature",
+			fn:        "T.Greet",
+			signature: true,
+			out:       "func (t *T) Greet(name string)",
+		},
+		{
+			name: "method",
+			fn:   "T.Greet",
+			out:  "func (t *T) Greet(name string) {\n\tfmt.Println(Hello(name))\n}",
+		},
+		{
+			name: "method of a generic type",
+			fn:   "P.Get",
+			out:  "func (p P[K, V]) Get(k K) V {\n\treturn p[k]\n}",
+		},
+		{
+			name: "method without receiver",
+			fn:   "Greet",
+			err:  "could not find func Greet",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := extractGoFunc([]byte(goContent), tt.fn, tt.signature)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.out {
+				t.Errorf("case [%s]: expected extracting %q; got %q", tt.name, tt.out, b)
+			}
+		})
+	}
+}
+
+func TestVerifyGo(t *testing.T) {
+	files := fakeFetcher{
+		"valid.go":   goContent,
+		"invalid.go": "package main\n\nfunc main() {\n\tfmt.Println(\n}\n",
+		"partial.go": "func main() {\n\t// START a\n\tif x {\n\t// END a\n}\n",
+	}
+	tc := []struct {
+		name string
+		in   string
+		err  string
+	}{
+		{name: "valid file", in: "[embedmd]:# (valid.go)\n"},
+		{name: "syntax error", in: "[embedmd]:# (invalid.go)\n", err: "1: invalid Go code in invalid.go: 5:1: "},
+		{name: "fragment", in: "[embedmd]:# (partial.go go a)\n"},
+		{name: "other language", in: "[embedmd]:# (invalid.go lang=text)\n"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Process(ioutil.Discard, strings.NewReader(tt.in), WithFetcher(files), WithVerifyGo(true))
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("case [%s]: unexpected error %v", tt.name, err)
+				}
+				return
+			}
+			// parser messages vary across Go versions, check only the position.
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("case [%s]: expected error starting with %q; got %v", tt.name, tt.err, err)
+			}
+		})
+	}
+}
+
+func TestTabWidth(t *testing.T) {
+	files := fakeFetcher{
+		"code.go":  "func main() {\n\tfmt.Println(\"a\tb\")\n\tusage := `\n\tflags:\n\t`\n\tif ok {\n\t\treturn\n\t}\n}\n",
+		"Makefile": "all:\n\tgo\tbuild\n",
+	}
+	tc := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "go literals",
+			in:   "[embedmd]:# (code.go)\n",
+			out:  "```go\nfunc main() {\n  fmt.Println(\"a\tb\")\n  usage := `\n\tflags:\n\t`\n  if ok {\n    return\n  }\n}\n```\n",
+		},
+		{
+			name: "other languages",
+			in:   "[embedmd]:# (Makefile lang=makefile)\n",
+			out:  "```makefile\nall:\n  go\tbuild\n```\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in), WithFetcher(files), WithTabWidth(2)); err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestTrimDanglingTokens(t *testing.T) {
+	files := fakeFetcher{
+		"code.go": "var people = []Person{\n\t{Name: \"Ada\"},\n\t{Name: \"Bo\"},\n}\n\nfunc main() {\n\tfmt.Println(len(people)) // people,\n}\n",
+	}
+	tc := []struct {
+		name string
+		in   string
+		opts []Option
+		out  string
+	}{
+		{
+			name: "trailing comma",
+			in:   "[embedmd]:# (code.go /var people/ /Ada.*,/)\n",
+			opts: []Option{WithTrimDanglingTokens(true)},
+			out:  "```go\nvar people = []Person{\n\t{Name: \"Ada\"}\n```\n",
+		},
+		{
+			name: "opening brace",
+			in:   "[embedmd]:# (code.go /func main/ /{/)\n",
+			opts: []Option{WithTrimDanglingTokens(true)},
+			out:  "```go\nfunc main()\n```\n",
+		},
+		{
+			name: "comment",
+			in:   "[embedmd]:# (code.go /\\tfmt.*,/)\n",
+			opts: []Option{WithTrimDanglingTokens(true)},
+			out:  "```go\nfmt.Println(len(people)) // people,\n```\n",
+		},
+		{
+			name: "other languages",
+			in:   "[embedmd]:# (code.go text /var people/ /Ada.*,/)\n",
+			opts: []Option{WithTrimDanglingTokens(true)},
+			out:  "```text\nvar people = []Person{\n\t{Name: \"Ada\"},\n```\n",
+		},
+		{
+			name: "off by default",
+			in:   "[embedmd]:# (code.go /var people/ /Ada.*,/)\n",
+			out:  "```go\nvar people = []Person{\n\t{Name: \"Ada\"},\n```\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in), append(tt.opts, WithFetcher(files))...); err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestShowImports(t *testing.T) {
+	files := fakeFetcher{
+		"code.go": "package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n\tstr \"strings\"\n)\n\n" +
+			"func Shout(name string) {\n\tfmt.Println(str.ToUpper(name))\n}\n\n" +
+			"func Write(f *os.File) {\n\tf.WriteString(\"hi\")\n}\n\n" +
+			"func Add(a, b int) int {\n\treturn a + b\n}\n",
+		"versions.go": "package main\n\nimport (\n\t\"gopkg.in/yaml.v2\"\n\t\"github.com/jackc/pgx/v5\"\n\t\"github.com/mattn/go-isatty\"\n)\n\n" +
+			"func Load(c *pgx.Conn) error {\n\tisatty.IsTerminal(0)\n\treturn yaml.Unmarshal(nil, c)\n}\n",
+	}
+	tc := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "fmt and strings",
+			in:   "[embedmd]:# (code.go func=Shout)\n",
+			out:  "```go\nimport (\n\t\"fmt\"\n\tstr \"strings\"\n)\n\nfunc Shout(name string) {\n\tfmt.Println(str.ToUpper(name))\n}\n```\n",
+		},
+		{
+			name: "signature",
+			in:   "[embedmd]:# (code.go func=Write signature)\n",
+			out:  "```go\nimport (\n\t\"os\"\n)\n\nfunc Write(f *os.File)\n```\n",
+		},
+		{
+			name: "versioned paths",
+			in:   "[embedmd]:# (versions.go func=Load)\n",
+			out:  "```go\nimport (\n\t\"gopkg.in/yaml.v2\"\n\t\"github.com/jackc/pgx/v5\"\n\t\"github.com/mattn/go-isatty\"\n)\n\nfunc Load(c *pgx.Conn) error {\n\tisatty.IsTerminal(0)\n\treturn yaml.Unmarshal(nil, c)\n}\n```\n",
+		},
+		{
+			name: "no imports",
+			in:   "[embedmd]:# (code.go func=Add)\n",
+			out:  "```go\nfunc Add(a, b int) int {\n\treturn a + b\n}\n```\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in), WithFetcher(files), WithShowImports(true)); err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestWrapMain(t *testing.T) {
+	files := fakeFetcher{
+		"bare.go":  "x := 1\n\nfmt.Println(x)\n",
+		"code.go":  "package main\n\ntype T int\n\nfunc main() {\n\tfmt.Println(T(1))\n}\n",
+		"main.go":  "// Package main says hello.\npackage main\n\nfunc main() {}\n",
+		"hello.sh": "echo hello\n",
+	}
+
+	tc := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "bare statements",
+			in:   "[embedmd]:# (bare.go)\n",
+			out:  "```go\npackage main\n\nfunc main() {\n\tx := 1\n\n\tfmt.Println(x)\n}\n```\n",
+		},
+		{
+			name: "func",
+			in:   "[embedmd]:# (code.go go func=main)\n",
+			out:  "```go\npackage main\n\nfunc main() {\n\tfmt.Println(T(1))\n}\n```\n",
+		},
+		{
+			name: "declarations",
+			in:   "[embedmd]:# (code.go go /type/ /^}/)\n",
+			out:  "```go\npackage main\n\ntype T int\n\nfunc main() {\n\tfmt.Println(T(1))\n}\n```\n",
+		},
+		{
+			name: "full file",
+			in:   "[embedmd]:# (main.go)\n",
+			out:  "```go\n// Package main says hello.\npackage main\n\nfunc main() {}\n```\n",
+		},
+		{
+			name: "not go",
+			in:   "[embedmd]:# (hello.sh)\n",
+			out:  "```sh\necho hello\n```\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in), WithFetcher(files), WithWrapMain(true)); err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestExported(t *testing.T) {
+	files := fakeFetcher{
+		"api.go": `package api
+
+import "fmt"
+
+// Greet says hello.
+func Greet(name string) {
+	fmt.Println(greeting(name))
+}
+
+func greeting(name string) string {
+	return "hello " + name
+}
+
+// Server serves greetings.
+type Server struct {
+	Addr string
+}
+
+// Start starts the server.
+func (s *Server) Start() error {
+	return nil
+}
+
+type handler struct{}
+
+func (handler) Handle() {}
+
+const (
+	// Version is the version of the API.
+	Version = "1.0"
+	build   = "dev"
+)
+
+var debug = false
+`,
+		"internal.go": "package api\n\nfunc helper() {}\n",
+		"generic.go":  "package api\n\n// Pair holds two values.\ntype Pair[K, V any] struct{}\n\n// Swap swaps them.\nfunc (p *Pair[K, V]) Swap() {}\n",
+	}
+
+	tc := []struct {
+		name string
+		in   string
+		out  string
+		err  string
+	}{
+		{
+			name: "exported declarations",
+			in:   "[embedmd]:# (api.go exported)\n",
+			out: "```go\n" +
+				"// Greet says hello.\nfunc Greet(name string) {\n\t// ...\n}\n\n" +
+				"// Server serves greetings.\ntype Server struct {\n\tAddr string\n}\n\n" +
+				"// Start starts the server.\nfunc (s *Server) Start() error {\n\t// ...\n}\n\n" +
+				"const (\n\t// Version is the version of the API.\n\tVersion = \"1.0\"\n)\n" +
+				"```\n",
+		},
+		{
+			name: "methods of generic types",
+			in:   "[embedmd]:# (generic.go exported)\n",
+			out:  "```go\n// Pair holds two values.\ntype Pair[K, V any] struct{}\n\n// Swap swaps them.\nfunc (p *Pair[K, V]) Swap() {\n\t// ...\n}\n```\n",
+		},
+		{
+			name: "no exported declarations",
+			in:   "[embedmd]:# (internal.go exported)\n",
+			err:  "could not extract content from internal.go: could not find exported declarations",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Process(&out, strings.NewReader(tt.in), WithFetcher(files))
+			if tt.err != "" {
+				if err == nil || !strings.HasSuffix(err.Error(), tt.err) {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestPackageDoc(t *testing.T) {
+	files := fakeFetcher{
+		"line.go":    "// Copyright 2024 Example.\n\n// Package line does *things*.\n//\n// It does them well.\npackage line\n\nfunc Do() {}\n",
+		"block.go":   "/*\nPackage block does things.\n\n  go run block\n*/\npackage block\n",
+		"nodoc.go":   "// Copyright 2024 Example.\n\npackage nodoc\n",
+		"invalid.go": "// Package invalid.\nfunc main() {}\n",
+	}
+
+	tc := []struct {
+		name string
+		in   string
+		out  string
+		err  string
+	}{
+		{
+			name: "line comments",
+			in:   "[embedmd]:# (line.go pkgdoc)\n",
+			out:  "<!-- embedmd:raw -->\nPackage line does *things*.\n\nIt does them well.\n<!-- embedmd:endraw -->\n",
+		},
+		{
+			name: "block comment",
+			in:   "[embedmd]:# (block.go pkgdoc)\n",
+			out:  "<!-- embedmd:raw -->\nPackage block does things.\n\n  go run block\n<!-- embedmd:endraw -->\n",
+		},
+		{
+			name: "no doc comment",
+			in:   "[embedmd]:# (nodoc.go pkgdoc)\n",
+			err:  "could not extract content from nodoc.go: could not find package doc comment",
+		},
+		{
+			name: "no package clause",
+			in:   "[embedmd]:# (invalid.go pkgdoc)\n",
+			err:  "could not extract content from invalid.go: 2:1: expected 'package', found 'func'",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Process(&out, strings.NewReader(tt.in), WithFetcher(files))
+			if tt.err != "" {
+				if err == nil || !strings.HasSuffix(err.Error(), tt.err) {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+
+			// The doc comment is replaced when processing the output again.
+			var again bytes.Buffer
+			if err := Process(&again, strings.NewReader(out.String()+"\ntext\n"), WithFetcher(files)); err != nil {
+				t.Fatal(err)
+			}
+			if want := out.String() + "\ntext\n"; again.String() != want {
+				t.Errorf("case [%s]: expected output %q when processing again; got %q", tt.name, want, again.String())
+			}
+		})
+	}
+}
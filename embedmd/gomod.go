@@ -0,0 +1,47 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"golang.org/x/mod/modfile"
+)
+
+// extractGoModVersion returns the version of the module required by the given
+// go.mod file or, if module is empty, the Go version in its go directive.
+func extractGoModVersion(b []byte, module string) ([]byte, error) {
+	f, err := modfile.ParseLax("go.mod", b, nil)
+	if err != nil {
+		return nil, err
+	}
+	if module == "" {
+		if f.Go == nil {
+			return nil, errors.New("missing go directive")
+		}
+		return []byte(f.Go.Version + "\n"), nil
+	}
+	for _, r := range f.Require {
+		if r.Mod.Path == module {
+			return []byte(r.Mod.Version + "\n"), nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not required", module)
+}
+
+// versionRE matches the lines written by gomod commands, either module
+// versions like v1.2.3 or Go versions like 1.21.
+var versionRE = regexp.MustCompile(`^(v\d+\.\d+\.\d+\S*|\d+\.\d+(\.\d+)?\S*)$`)
@@ -0,0 +1,80 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// redactedHeaders are the response headers masked when embedding headers,
+// unless WithRedactedHeaders is given.
+var redactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "Set-Cookie"}
+
+// WithAllowHeaderEmbed enables the headers keyword, which embeds the status
+// line and headers of the response to a HEAD request to the given URL. The
+// request is sent by the Fetcher, which must be a HeadFetcher as the default
+// one is.
+func WithAllowHeaderEmbed(allow bool) Option {
+	return Option{func(e *embedder) { e.headerEmbed = allow }}
+}
+
+// WithRedactedHeaders sets the response headers whose values are replaced by
+// REDACTED when embedding headers. By default Authorization, Cookie,
+// Proxy-Authorization and Set-Cookie are redacted.
+func WithRedactedHeaders(names ...string) Option {
+	return Option{func(e *embedder) { e.redactedHeaders = names }}
+}
+
+// fetchHeaders returns the status line and the headers, sorted by name, of
+// the response to a HEAD request to url.
+func (e *embedder) fetchHeaders(url string) ([]byte, error) {
+	if !e.headerEmbed {
+		return nil, errors.New("header embedding is not enabled")
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, errors.New("headers requires a URL")
+	}
+	res, err := head(e.Fetcher, url)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	redacted := make(map[string]bool)
+	for _, name := range e.redactedHeaders {
+		redacted[textproto.CanonicalMIMEHeaderKey(name)] = true
+	}
+	var names []string
+	for name := range res.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s\n", res.Proto, res.Status)
+	for _, name := range names {
+		for _, v := range res.Header[name] {
+			if redacted[name] {
+				v = "REDACTED"
+			}
+			fmt.Fprintf(&buf, "%s: %s\n", name, v)
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHeaderEmbed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("expected a HEAD request; got %s", r.Method)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Rate-Limit", "100")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("X-Api-Token", "secret")
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			w.Header().Set("X-Seen-Authorization", auth)
+		}
+	}))
+	defer srv.Close()
+
+	tc := []struct {
+		name     string
+		opts     []Option
+		contains []string
+		err      string
+	}{
+		{
+			name:     "default redaction",
+			opts:     []Option{WithAllowHeaderEmbed(true)},
+			contains: []string{"```http\nHTTP/1.1 200 OK\n", "Content-Type: application/json\n", "X-Rate-Limit: 100\n", "Set-Cookie: REDACTED\n", "X-Api-Token: secret\n"},
+		},
+		{
+			name:     "configured redaction",
+			opts:     []Option{WithAllowHeaderEmbed(true), WithRedactedHeaders("x-api-token")},
+			contains: []string{"X-Api-Token: REDACTED\n", "Set-Cookie: session=secret\n"},
+		},
+		{
+			name:     "authorized",
+			opts:     []Option{WithAllowHeaderEmbed(true), WithAuth(srv.Listener.Addr().String(), "Bearer", "token"), WithInsecureAuth(true)},
+			contains: []string{"X-Seen-Authorization: Bearer token\n"},
+		},
+		{
+			name: "not a HeadFetcher",
+			opts: []Option{WithAllowHeaderEmbed(true), WithFetcher(fakeFetcher{})},
+			err:  "1: could not read " + srv.URL + ": headers requires a HeadFetcher",
+		},
+		{
+			name: "not allowed",
+			err:  "1: could not read " + srv.URL + ": header embedding is not enabled",
+		},
+	}
+
+	in := "[embedmd]:# (" + srv.URL + " headers)\n"
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Process(&out, strings.NewReader(in), tt.opts...)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(out.String(), s) {
+					t.Errorf("case [%s]: expected %q in output %q", tt.name, s, out.String())
+				}
+			}
+		})
+	}
+}
+
+func TestHeaderEmbedCaching(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Authorization", r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+
+	// MultiProcess wraps the configured Fetcher in a CachingFetcher.
+	in := "[embedmd]:# (" + srv.URL + " headers)\n"
+	var out bytes.Buffer
+	err := MultiProcess([]Document{{In: strings.NewReader(in), Out: &out}},
+		WithAllowHeaderEmbed(true), WithAuth(srv.Listener.Addr().String(), "Bearer", "token"), WithInsecureAuth(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "X-Seen-Authorization: Bearer token\n"; !strings.Contains(out.String(), want) {
+		t.Errorf("expected %q in output %q", want, out.String())
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// extractCSS returns the outer HTML of the first element in the given HTML
+// document matching the selector.
+func extractCSS(b []byte, selector string) ([]byte, error) {
+	sel, err := parseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := html.Parse(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	n := findNode(doc, sel.matches)
+	if n == nil {
+		return nil, fmt.Errorf("no element matches %q", selector)
+	}
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, n); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// A selector is a simple CSS selector: an optional tag name followed by any
+// number of #id and .class selectors, such as div.example or #main.
+type selector struct {
+	tag, id string
+	classes []string
+}
+
+func parseSelector(s string) (*selector, error) {
+	if s == "" || strings.ContainsAny(s, " >+~*,:[]") {
+		return nil, fmt.Errorf("unsupported selector %q", s)
+	}
+
+	sel := &selector{}
+	for rest := s; rest != ""; {
+		i := strings.IndexAny(rest[1:], ".#") + 1
+		if i == 0 {
+			i = len(rest)
+		}
+		part := rest[:i]
+		rest = rest[i:]
+
+		switch {
+		case part == "." || part == "#":
+			return nil, fmt.Errorf("unsupported selector %q", s)
+		case part[0] == '.':
+			sel.classes = append(sel.classes, part[1:])
+		case part[0] == '#':
+			sel.id = part[1:]
+		default:
+			sel.tag = part
+		}
+	}
+	return sel, nil
+}
+
+func (sel *selector) matches(n *html.Node) bool {
+	if n.Type != html.ElementNode || (sel.tag != "" && n.Data != sel.tag) {
+		return false
+	}
+	if sel.id != "" && attr(n, "id") != sel.id {
+		return false
+	}
+	classes := strings.Fields(attr(n, "class"))
+	for _, want := range sel.classes {
+		found := false
+		for _, c := range classes {
+			found = found || c == want
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// findNode returns the first node in document order for which match is true.
+func findNode(n *html.Node, match func(*html.Node) bool) *html.Node {
+	if match(n) {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findNode(c, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
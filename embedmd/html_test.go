@@ -0,0 +1,84 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"testing"
+)
+
+const page = `<!DOCTYPE html>
+<html>
+<body>
+<div id="main">
+	<p class="intro">Hello</p>
+	<div class="example card">
+		<span>Example</span>
+	</div>
+</div>
+</body>
+</html>
+`
+
+func TestExtractCSS(t *testing.T) {
+	tc := []struct {
+		name     string
+		selector string
+		out      string
+		err      string
+	}{
+		{
+			name:     "by class",
+			selector: ".example",
+			out:      "<div class=\"example card\">\n\t\t<span>Example</span>\n\t</div>",
+		},
+		{
+			name:     "by tag and class",
+			selector: "p.intro",
+			out:      `<p class="intro">Hello</p>`,
+		},
+		{
+			name:     "by id",
+			selector: "#main",
+			out:      "<div id=\"main\">\n\t<p class=\"intro\">Hello</p>\n\t<div class=\"example card\">\n\t\t<span>Example</span>\n\t</div>\n</div>",
+		},
+		{
+			name:     "no match",
+			selector: "div.missing",
+			err:      `no element matches "div.missing"`,
+		},
+		{
+			name:     "unsupported",
+			selector: "div > p",
+			err:      `unsupported selector "div > p"`,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := extractCSS([]byte(page), tt.selector)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.out {
+				t.Errorf("case [%s]: expected extracting %q; got %q", tt.name, tt.out, b)
+			}
+		})
+	}
+}
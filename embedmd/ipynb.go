@@ -0,0 +1,55 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// notebook holds the parts of a Jupyter notebook used to extract cells.
+type notebook struct {
+	Cells []struct {
+		CellType string          `json:"cell_type"`
+		Source   json.RawMessage `json:"source"`
+	} `json:"cells"`
+}
+
+// extractNotebookCell returns the source of the nth cell, starting at 1, of
+// the given Jupyter notebook. The cell must be a code cell.
+func extractNotebookCell(b []byte, n int) ([]byte, error) {
+	var nb notebook
+	if err := json.Unmarshal(b, &nb); err != nil {
+		return nil, fmt.Errorf("could not parse notebook: %v", err)
+	}
+	if n < 1 || n > len(nb.Cells) {
+		return nil, fmt.Errorf("cell %d out of range, the notebook has %d cells", n, len(nb.Cells))
+	}
+	cell := nb.Cells[n-1]
+	if cell.CellType != "code" {
+		return nil, fmt.Errorf("cell %d is a %s cell, not a code cell", n, cell.CellType)
+	}
+
+	// the source is either a string or a list of lines.
+	var lines []string
+	if err := json.Unmarshal(cell.Source, &lines); err != nil {
+		var s string
+		if err := json.Unmarshal(cell.Source, &s); err != nil {
+			return nil, fmt.Errorf("bad source in cell %d", n)
+		}
+		lines = []string{s}
+	}
+	return []byte(strings.Join(lines, "")), nil
+}
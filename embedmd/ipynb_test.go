@@ -0,0 +1,61 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"testing"
+)
+
+const testNotebook = `{
+ "cells": [
+  {"cell_type": "markdown", "metadata": {}, "source": ["# Title\n"]},
+  {"cell_type": "code", "metadata": {}, "outputs": [], "source": ["import numpy as np\n", "np.zeros(3)"]},
+  {"cell_type": "code", "metadata": {}, "outputs": [], "source": "print('hi')\n"}
+ ],
+ "metadata": {},
+ "nbformat": 4,
+ "nbformat_minor": 5
+}`
+
+func TestExtractNotebookCell(t *testing.T) {
+	tc := []struct {
+		name string
+		cell int
+		out  string
+		err  string
+	}{
+		{name: "code cell with lines", cell: 2, out: "import numpy as np\nnp.zeros(3)"},
+		{name: "code cell with a string", cell: 3, out: "print('hi')\n"},
+		{name: "markdown cell", cell: 1, err: "cell 1 is a markdown cell, not a code cell"},
+		{name: "out of range", cell: 4, err: "cell 4 out of range, the notebook has 3 cells"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := extractNotebookCell([]byte(testNotebook), tt.cell)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.out {
+				t.Errorf("case [%s]: expected %q; got %q", tt.name, tt.out, b)
+			}
+		})
+	}
+}
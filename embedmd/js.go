@@ -0,0 +1,66 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// jsLangs are the languages, or file extensions, whose functions are extracted
+// by jsSymbolExtract.
+var jsLangs = map[string]bool{
+	"js": true, "javascript": true, "jsx": true, "mjs": true, "cjs": true,
+	"ts": true, "typescript": true, "tsx": true, "mts": true, "cts": true,
+}
+
+// isJS reports whether the content of a command, of the given language and
+// path, is JavaScript or TypeScript.
+func isJS(lang, p string) bool {
+	if lang != "" {
+		return jsLangs[lang]
+	}
+	return jsLangs[strings.TrimPrefix(path.Ext(p), ".")]
+}
+
+// jsSymbolExtract returns the JavaScript or TypeScript function named name,
+// declared as in:
+//
+//	function name(...) {
+//	const name = (...) => {
+//	name(...) {
+//
+// up to its closing brace, which is found ignoring braces in string literals
+// and comments. Modifiers such as export, async or static are accepted.
+func jsSymbolExtract(b []byte, name string) ([]byte, error) {
+	n := regexp.QuoteMeta(name)
+	forms := []string{
+		`^[ \t]*(export[ \t]+(default[ \t]+)?)?(async[ \t]+)?function([ \t]*\*[ \t]*|[ \t]+)` + n + `[ \t]*[(<]`,
+		`^[ \t]*(export[ \t]+)?(const|let|var)[ \t]+` + n + `[ \t]*(:[^=]*)?=[ \t]*(async[ \t]+)?(\([^)]*\)|[\pL_$][\pL\pN_$]*)[^=\n]*=>[ \t]*\{`,
+		`^[ \t]*((static|async|public|private|protected|override|get|set)[ \t]+)*\*?` + n + `[ \t]*(<[^>\n]*>)?\([^)]*\)[^;{\n]*\{`,
+	}
+	for _, form := range forms {
+		loc := regexp.MustCompile(`(?m)` + form).FindIndex(b)
+		if loc == nil {
+			continue
+		}
+		if block := braceBlock(b, loc[0]); block != nil {
+			return block, nil
+		}
+		return nil, fmt.Errorf("unbalanced braces after func %s", name)
+	}
+	return nil, fmt.Errorf("could not find func %s", name)
+}
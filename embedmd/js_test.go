@@ -0,0 +1,114 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const jsContent = `import { serve } from "./server.js";
+
+// handler answers every request.
+export async function handler(req) {
+  if (req.url === "/}") {
+    return { status: 404 }; // not found: }
+  }
+  return { status: 200, body: ` + "`{${req.url}`" + ` };
+}
+
+const double = (x) => x * 2;
+
+export const greet = async (name: string): Promise<string> => {
+  /* braces in comments are ignored: { */
+  return "hello, " + name;
+};
+
+class Greeter {
+  static create() {
+    return new Greeter();
+  }
+
+  greet(name) {
+    console.log(greet(name));
+  }
+}
+`
+
+func TestJSSymbolExtract(t *testing.T) {
+	tc := []struct {
+		name string
+		fn   string
+		out  string
+		err  string
+	}{
+		{
+			name: "function declaration",
+			fn:   "handler",
+			out:  "export async function handler(req) {\n  if (req.url === \"/}\") {\n    return { status: 404 }; // not found: }\n  }\n  return { status: 200, body: `{${req.url}` };\n}\n",
+		},
+		{
+			name: "arrow function const",
+			fn:   "greet",
+			out:  "export const greet = async (name: string): Promise<string> => {\n  /* braces in comments are ignored: { */\n  return \"hello, \" + name;\n};\n",
+		},
+		{
+			name: "method",
+			fn:   "create",
+			out:  "  static create() {\n    return new Greeter();\n  }\n",
+		},
+		{
+			name: "arrow function without block",
+			fn:   "double",
+			err:  "could not find func double",
+		},
+		{
+			name: "missing function",
+			fn:   "serve",
+			err:  "could not find func serve",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := jsSymbolExtract([]byte(jsContent), tt.fn)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.out {
+				t.Errorf("case [%s]: expected extracting %q; got %q", tt.name, tt.out, b)
+			}
+		})
+	}
+}
+
+func TestProcessJSFunc(t *testing.T) {
+	files := fakeFetcher{"app.ts": jsContent}
+	in := "[embedmd]:# (app.ts func=greet)\n"
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithFetcher(files)); err != nil {
+		t.Fatal(err)
+	}
+	want := in + "```ts\nexport const greet = async (name: string): Promise<string> => {\n  /* braces in comments are ignored: { */\n  return \"hello, \" + name;\n};\n```\n"
+	if out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+}
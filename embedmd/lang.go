@@ -0,0 +1,141 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// knownExtensions are the file extensions that are also the name of the
+// language of the file, as used by WithRequireLanguage.
+var knownExtensions = map[string]bool{
+	"bash": true, "c": true, "cpp": true, "cs": true, "css": true,
+	"dart": true, "go": true, "graphql": true, "html": true, "java": true,
+	"js": true, "json": true, "kt": true, "lua": true, "php": true,
+	"proto": true, "py": true, "r": true, "rb": true, "rs": true,
+	"scala": true, "sh": true, "sql": true, "swift": true, "toml": true,
+	"ts": true, "xml": true, "yaml": true, "yml": true, "zsh": true,
+}
+
+// interpreters maps the interpreters found in shebang lines to languages.
+var interpreters = map[string]string{
+	"bash":   "bash",
+	"sh":     "sh",
+	"zsh":    "zsh",
+	"fish":   "fish",
+	"python": "python",
+	"node":   "javascript",
+	"deno":   "typescript",
+	"ruby":   "ruby",
+	"perl":   "perl",
+	"php":    "php",
+	"lua":    "lua",
+	"awk":    "awk",
+	"tclsh":  "tcl",
+}
+
+// shebangLang returns the language of the interpreter named in the shebang
+// line starting b, such as #!/usr/bin/env python3, or "" if it is unknown.
+func shebangLang(b []byte) string {
+	if !bytes.HasPrefix(b, []byte("#!")) {
+		return ""
+	}
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		b = b[:i]
+	}
+	args := strings.Fields(string(b[2:]))
+	if len(args) > 0 && path.Base(args[0]) == "env" {
+		args = args[1:]
+		// skip the options of env, such as -S.
+		for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+			args = args[1:]
+		}
+	}
+	if len(args) == 0 {
+		return ""
+	}
+	// remove versions, as in python3 or python3.11.
+	name := strings.TrimRight(path.Base(args[0]), "0123456789.")
+	return interpreters[name]
+}
+
+// yamlKeyRE matches the lines starting with a YAML mapping key.
+var yamlKeyRE = regexp.MustCompile(`^[A-Za-z_][\w.-]*:(\s|$)`)
+
+// contentLang guesses the language of b from its content: JSON if it is a valid
+// JSON object or array, HTML or XML if it starts with a tag, and YAML if its
+// first line, after comments, is a document marker or a mapping key. It
+// returns "" if none of them match.
+func contentLang(b []byte) string {
+	b = bytes.TrimSpace(b)
+	lower := bytes.ToLower(b)
+	switch {
+	case len(b) == 0:
+		return ""
+	case (b[0] == '{' || b[0] == '[') && json.Valid(b):
+		return "json"
+	case bytes.HasPrefix(lower, []byte("<!doctype html")) || bytes.HasPrefix(lower, []byte("<html")):
+		return "html"
+	case b[0] == '<':
+		return "xml"
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimRight(line, "\r \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "---" || yamlKeyRE.MatchString(line) {
+			return "yaml"
+		}
+		break
+	}
+	return ""
+}
+
+// looksBinary reports whether b does not look like text: it contains a NUL
+// byte, or more than a tenth of it is invalid UTF-8 or control characters
+// other than spaces.
+func looksBinary(b []byte) bool {
+	if bytes.IndexByte(b, 0) >= 0 {
+		return true
+	}
+	var bad, n int
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size == 1 || unicode.IsControl(r) && !unicode.IsSpace(r) {
+			bad++
+		}
+		b = b[size:]
+		n++
+	}
+	return bad*10 > n
+}
+
+// printable returns a copy of b where the invalid UTF-8 and the control
+// characters other than spaces, which looksBinary counts, are replaced by the
+// Unicode replacement character.
+func printable(b []byte) []byte {
+	return bytes.Map(func(r rune) rune {
+		if unicode.IsControl(r) && !unicode.IsSpace(r) {
+			return utf8.RuneError
+		}
+		return r
+	}, b)
+}
@@ -0,0 +1,211 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestShebangLang(t *testing.T) {
+	tc := []struct {
+		in   string
+		lang string
+	}{
+		{in: "#!/bin/bash\necho hi\n", lang: "bash"},
+		{in: "#!/usr/bin/env node\nconsole.log(1)\n", lang: "javascript"},
+		{in: "#!/usr/bin/env python3\n", lang: "python"},
+		{in: "#!/usr/bin/python3.11 -u\n", lang: "python"},
+		{in: "#!/usr/bin/env -S ruby -w\n", lang: "ruby"},
+		{in: "#!/bin/sh", lang: "sh"},
+		{in: "#!/usr/bin/unknown\n", lang: ""},
+		{in: "#!\n", lang: ""},
+		{in: "echo hi\n", lang: ""},
+	}
+
+	for _, tt := range tc {
+		if got := shebangLang([]byte(tt.in)); got != tt.lang {
+			t.Errorf("shebangLang(%q): expected %q; got %q", tt.in, tt.lang, got)
+		}
+	}
+}
+
+func TestContentLang(t *testing.T) {
+	tc := []struct {
+		in   string
+		lang string
+	}{
+		{in: "{\"name\": \"embedmd\"}\n", lang: "json"},
+		{in: "\n  [1, 2, 3]", lang: "json"},
+		{in: "{ not json", lang: ""},
+		{in: "<?xml version=\"1.0\"?>\n<project/>\n", lang: "xml"},
+		{in: "<project><name>x</name></project>", lang: "xml"},
+		{in: "<!DOCTYPE html>\n<html></html>\n", lang: "html"},
+		{in: "# config\nname: embedmd\nversion: 1\n", lang: "yaml"},
+		{in: "---\n- a\n", lang: "yaml"},
+		{in: "echo hi\n", lang: ""},
+		{in: "", lang: ""},
+	}
+
+	for _, tt := range tc {
+		if got := contentLang([]byte(tt.in)); got != tt.lang {
+			t.Errorf("contentLang(%q): expected %q; got %q", tt.in, tt.lang, got)
+		}
+	}
+}
+
+func TestContentLangDetection(t *testing.T) {
+	opts := []Option{
+		WithNamedSource("status", strings.NewReader(`{"ok": true}`+"\n")),
+		WithNamedSource("pom", strings.NewReader("<project>\n  <version>1</version>\n</project>\n")),
+		WithNamedSource("notes", strings.NewReader("hello\n")),
+		WithContentLangDetection(true),
+	}
+	tc := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{name: "json", in: "[embedmd]:# (named:status)\n", out: "```json\n{\"ok\": true}\n```\n"},
+		{name: "xml", in: "[embedmd]:# (named:pom)\n", out: "```xml\n<project>\n  <version>1</version>\n</project>\n```\n"},
+		{name: "unknown", in: "[embedmd]:# (named:notes)\n", out: "```\nhello\n```\n"},
+		{name: "command language", in: "[embedmd]:# (named:status lang=text)\n", out: "```text\n{\"ok\": true}\n```\n"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in), opts...); err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestProcessShebang(t *testing.T) {
+	files := fakeFetcher{
+		"build":     "#!/bin/bash\nset -e\n",
+		"tool.js":   "#!/usr/bin/env node\n",
+		"scripts/x": "#!/usr/bin/env node\nrun()\n",
+	}
+	tc := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{name: "bash", in: "[embedmd]:# (build)\n", out: "```bash\n#!/bin/bash\nset -e\n```\n"},
+		{name: "node", in: "[embedmd]:# (scripts/x /run/)\n", out: "```javascript\nrun\n```\n"},
+		{name: "extension first", in: "[embedmd]:# (tool.js)\n", out: "```js\n#!/usr/bin/env node\n```\n"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in), WithFetcher(files)); err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestRequireLanguage(t *testing.T) {
+	files := fakeFetcher{
+		"code.go":   "package main\n",
+		"data.dat":  "1 2 3\n",
+		"run":       "#!/bin/sh\n",
+		"notes.txt": "hello\n",
+	}
+	tc := []struct {
+		name string
+		in   string
+		out  string
+		err  string
+	}{
+		{name: "inferred from extension", in: "[embedmd]:# (code.go)\n", out: "```go\npackage main\n```\n"},
+		{name: "unknown extension", in: "[embedmd]:# (data.dat)\n", err: "1: missing language for data.dat, it cannot be guessed"},
+		{name: "explicit language", in: "[embedmd]:# (data.dat lang=text)\n", out: "```text\n1 2 3\n```\n"},
+		{name: "shebang", in: "[embedmd]:# (run)\n", out: "```sh\n#!/bin/sh\n```\n"},
+		{name: "raw", in: "[embedmd]:# (notes.txt raw)\n", out: "<!-- embedmd:raw -->\nhello\n<!-- embedmd:endraw -->\n"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Process(&out, strings.NewReader(tt.in), WithFetcher(files), WithRequireLanguage(true))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestLooksBinary(t *testing.T) {
+	tc := []struct {
+		name string
+		in   string
+		want bool
+	}{
+		{name: "text", in: "package main\n\tfunc main() {}\r\n", want: false},
+		{name: "unicode", in: "héllo, 世界\n", want: false},
+		{name: "empty", in: "", want: false},
+		{name: "nul byte", in: "text\x00text", want: true},
+		{name: "control characters", in: "\x01\x02\x03\x7fabc\x1b", want: true},
+		{name: "invalid UTF-8", in: "\xff\xfe\xfd\xfcabcdef", want: true},
+		{name: "few control characters", in: "\x1b[1mbold text for a terminal\x1b[0m\n", want: false},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := looksBinary([]byte(tt.in)); got != tt.want {
+				t.Errorf("case [%s]: expected %v; got %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBinaryFallback(t *testing.T) {
+	files := fakeFetcher{
+		"image.go": "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x01\x02",
+		"code.go":  "package main\n",
+	}
+	var logs, out bytes.Buffer
+	in := "[embedmd]:# (image.go)\n\n[embedmd]:# (code.go)\n"
+	if err := Process(&out, strings.NewReader(in), WithFetcher(files), WithLogger(log.New(&logs, "", 0)), WithBinaryFallback(true)); err != nil {
+		t.Fatal(err)
+	}
+	want := "[embedmd]:# (image.go)\n```text\n\ufffdPNG\n\ufffd\n\ufffd\ufffd\ufffd\rIHDR\ufffd\ufffd\n```\n\n[embedmd]:# (code.go)\n```go\npackage main\n```\n"
+	if out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+	if want := "1: image.go does not look like text, embedding it as text instead of \"go\"\n"; logs.String() != want {
+		t.Errorf("expected warning %q; got %q", want, logs.String())
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// splitFragment returns the given URL without its fragment, and the unescaped
+// fragment. Paths of local files are returned unchanged, as # is valid in file
+// names.
+func splitFragment(p string) (string, string) {
+	if !strings.HasPrefix(p, "http://") && !strings.HasPrefix(p, "https://") {
+		return p, ""
+	}
+	i := strings.Index(p, "#")
+	if i < 0 {
+		return p, ""
+	}
+	fragment, err := url.PathUnescape(p[i+1:])
+	if err != nil {
+		fragment = p[i+1:]
+	}
+	return p[:i], fragment
+}
+
+// isMarkdown reports whether the file at the given path is a markdown file.
+func isMarkdown(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".md", ".markdown", ".mdown", ".mkd":
+		return true
+	}
+	return false
+}
+
+var headingRE = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+
+// extractMarkdownSection returns the section of the markdown b whose heading
+// has the given anchor, as generated by GitHub, or text. The section goes up to
+// the next heading of the same or a higher level. Headings in fenced code
+// blocks are ignored. It also returns the text of the headings of the sections
+// containing it, from the outermost one, followed by its own.
+func extractMarkdownSection(b []byte, anchor string) ([]byte, []string, error) {
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	from, level := -1, 0
+	offset, inCode := 0, false
+	var headings []string
+	var levels []int
+	for _, line := range lines {
+		text := strings.TrimRight(string(line), "\r\n")
+		if strings.HasPrefix(strings.TrimLeft(text, " "), "```") || strings.HasPrefix(strings.TrimLeft(text, " "), "~~~") {
+			inCode = !inCode
+		}
+		if m := headingRE.FindStringSubmatch(text); m != nil && !inCode {
+			switch {
+			case from >= 0 && len(m[1]) <= level:
+				return b[from:offset], headings, nil
+			case from < 0:
+				for len(levels) > 0 && levels[len(levels)-1] >= len(m[1]) {
+					headings, levels = headings[:len(headings)-1], levels[:len(levels)-1]
+				}
+				headings, levels = append(headings, m[2]), append(levels, len(m[1]))
+				if headingAnchor(m[2]) == anchor || m[2] == anchor {
+					from, level = offset, len(m[1])
+				}
+			}
+		}
+		offset += len(line)
+	}
+	if from < 0 {
+		return nil, nil, fmt.Errorf("could not find section %s", anchor)
+	}
+	return b[from:], headings, nil
+}
+
+// breadcrumb returns a quote naming the given headings, as in
+// > From: Guide > Setup, followed by a blank line.
+func breadcrumb(headings []string) []byte {
+	return []byte("> From: " + strings.Join(headings, " > ") + "\n\n")
+}
+
+// headingAnchor returns the anchor of the heading with the given text, as
+// generated by GitHub: in lower case, without punctuation, and with dashes
+// instead of spaces.
+func headingAnchor(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case r == ' ':
+			b.WriteRune('-')
+		case r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsNumber(r):
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// markdownEscaper escapes the characters markdown interprets as emphasis, code
+// spans, links, HTML, headings, tables or strikethrough.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`, "~", `\~`,
+)
+
+// escapeMarkdown escapes the markdown metacharacters in b with backslashes.
+func escapeMarkdown(b []byte) []byte {
+	return []byte(markdownEscaper.Replace(string(b)))
+}
@@ -0,0 +1,162 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const markdownContent = `# Project
+
+Intro.
+
+## Getting Started
+
+Install it:
+
+` + "```sh\n# not a heading\ngo get example.com/project\n```" + `
+
+### Requirements
+
+Go 1.21.
+
+## Usage, in short
+
+Run it.
+`
+
+func TestExtractMarkdownSection(t *testing.T) {
+	tc := []struct {
+		name   string
+		anchor string
+		out    string
+		heads  []string
+		err    string
+	}{
+		{
+			name:   "section with subsections",
+			anchor: "getting-started",
+			heads:  []string{"Project", "Getting Started"},
+			out:    "## Getting Started\n\nInstall it:\n\n```sh\n# not a heading\ngo get example.com/project\n```\n\n### Requirements\n\nGo 1.21.\n\n",
+		},
+		{
+			name:   "subsection",
+			anchor: "requirements",
+			heads:  []string{"Project", "Getting Started", "Requirements"},
+			out:    "### Requirements\n\nGo 1.21.\n\n",
+		},
+		{
+			name:   "punctuation and end of file",
+			anchor: "usage-in-short",
+			heads:  []string{"Project", "Usage, in short"},
+			out:    "## Usage, in short\n\nRun it.\n",
+		},
+		{
+			name:   "heading text",
+			anchor: "Getting Started",
+			heads:  []string{"Project", "Getting Started"},
+			out:    "## Getting Started\n\nInstall it:\n\n```sh\n# not a heading\ngo get example.com/project\n```\n\n### Requirements\n\nGo 1.21.\n\n",
+		},
+		{
+			name:   "heading in code block",
+			anchor: "not-a-heading",
+			err:    "could not find section not-a-heading",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			b, heads, err := extractMarkdownSection([]byte(markdownContent), tt.anchor)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.out {
+				t.Errorf("case [%s]: expected extracting %q; got %q", tt.name, tt.out, b)
+			}
+			if !reflect.DeepEqual(heads, tt.heads) {
+				t.Errorf("case [%s]: expected headings %q; got %q", tt.name, tt.heads, heads)
+			}
+		})
+	}
+}
+
+func TestProcessMarkdownSection(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, markdownContent)
+	}))
+	defer srv.Close()
+
+	in := "[embedmd]:# (" + srv.URL + "/README.md#usage-in-short)\n"
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	if want := in + "```md\n## Usage, in short\n\nRun it.\n```\n"; out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+}
+
+func TestBreadcrumb(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, markdownContent)
+	}))
+	defer srv.Close()
+
+	in := "[embedmd]:# (" + srv.URL + "/README.md#requirements)\n"
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithBreadcrumb(true)); err != nil {
+		t.Fatal(err)
+	}
+	if want := in + "```md\n> From: Project > Getting Started > Requirements\n\n### Requirements\n\nGo 1.21.\n\n```\n"; out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+}
+
+func TestEscapeRaw(t *testing.T) {
+	files := fakeFetcher{"notes.txt": "2 * 3 is `6`, see [docs] or file_name.go\n"}
+	in := "[embedmd]:# (notes.txt raw)\n"
+
+	tc := []struct {
+		name   string
+		escape bool
+		out    string
+	}{
+		{name: "as is", out: "2 * 3 is `6`, see [docs] or file_name.go\n"},
+		{name: "escaped", escape: true, out: "2 \\* 3 is \\`6\\`, see \\[docs\\] or file\\_name.go\n"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(in), WithFetcher(files), WithEscapeRaw(tt.escape)); err != nil {
+				t.Fatal(err)
+			}
+			if want := in + "<!-- embedmd:raw -->\n" + tt.out + "<!-- embedmd:endraw -->\n"; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
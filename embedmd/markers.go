@@ -0,0 +1,108 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// A MarkerIssue describes a problem with the START and END markers of a
+// sample found by ValidateMarkers.
+type MarkerIssue struct {
+	Line int    // line of the offending marker, starting at 1.
+	Name string // name of the sample.
+	Msg  string
+}
+
+func (m MarkerIssue) String() string { return fmt.Sprintf("%d: %s", m.Line, m.Msg) }
+
+// markers holds the settings used to recognize the markers of samples.
+type markers struct {
+	caseInsensitive bool
+}
+
+// keyword returns a POSIX regular expression matching the given keyword.
+func (m markers) keyword(kw string) string {
+	if !m.caseInsensitive {
+		return kw
+	}
+	var buf bytes.Buffer
+	for _, r := range kw {
+		fmt.Fprintf(&buf, "[%c%c]", unicode.ToUpper(r), unicode.ToLower(r))
+	}
+	return buf.String()
+}
+
+// lineRE returns a regular expression matching lines containing a marker,
+// capturing whether it is a start or end marker and the name of the sample.
+func (m markers) lineRE() *regexp.Regexp {
+	return regexp.MustCompile(`\b(` + m.keyword("START") + "|" + m.keyword("END") + `) (\S+)`)
+}
+
+// ValidateMarkers checks that every START marker in the given source has a
+// matching END marker, and that sample names are not reused. The issues found
+// are returned sorted by line. The given options configure how markers are
+// recognized, as they would for Process.
+func ValidateMarkers(src []byte, opts ...Option) ([]MarkerIssue, error) {
+	var e embedder
+	for _, opt := range opts {
+		opt.f(&e)
+	}
+
+	var issues []MarkerIssue
+	markerRE := e.markers.lineRE()
+	open := make(map[string]int)   // line of the START of samples not yet closed.
+	closed := make(map[string]int) // line of the START of samples already closed.
+	s := newLineScanner(bytes.NewReader(src), e.maxLineLength)
+	for line := 1; s.Scan(); line++ {
+		m := markerRE.FindStringSubmatch(s.Text())
+		if m == nil {
+			continue
+		}
+		name, isEnd := m[2], strings.ToUpper(m[1]) == "END"
+		switch start, isOpen := open[name]; {
+		case isEnd && isOpen:
+			delete(open, name)
+			closed[name] = start
+		case isEnd:
+			issues = append(issues, MarkerIssue{line, name, fmt.Sprintf("END %s without START", name)})
+		case isOpen:
+			issues = append(issues, MarkerIssue{line, name, fmt.Sprintf("START %s already opened at line %d", name, start)})
+		default:
+			if first, ok := closed[name]; ok {
+				issues = append(issues, MarkerIssue{line, name, fmt.Sprintf("duplicated sample %s, first defined at line %d", name, first)})
+			}
+			open[name] = line
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range open {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		issues = append(issues, MarkerIssue{open[name], name, fmt.Sprintf("START %s without END", name)})
+	}
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Line < issues[j].Line })
+	return issues, nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateMarkers(t *testing.T) {
+	tc := []struct {
+		name   string
+		src    string
+		issues []MarkerIssue
+	}{
+		{
+			name: "balanced",
+			src:  "// START a\na()\n// END a\n// START b\nb()\n// END b\n",
+		},
+		{
+			name: "unbalanced",
+			src:  "// START a\na()\n// START b\nb()\n// END b\n// END c\n",
+			issues: []MarkerIssue{
+				{Line: 1, Name: "a", Msg: "START a without END"},
+				{Line: 6, Name: "c", Msg: "END c without START"},
+			},
+		},
+		{
+			name: "duplicated name",
+			src:  "// START a\na()\n// END a\n\n// START a\nb()\n// END a\n",
+			issues: []MarkerIssue{
+				{Line: 5, Name: "a", Msg: "duplicated sample a, first defined at line 1"},
+			},
+		},
+		{
+			name: "duplicated and not closed",
+			src:  "// START b\n// END b\n// START c\n// START a\n// START b\n",
+			issues: []MarkerIssue{
+				{Line: 3, Name: "c", Msg: "START c without END"},
+				{Line: 4, Name: "a", Msg: "START a without END"},
+				{Line: 5, Name: "b", Msg: "duplicated sample b, first defined at line 1"},
+				{Line: 5, Name: "b", Msg: "START b without END"},
+			},
+		},
+		{
+			name: "reopened",
+			src:  "// START a\n// START a\n// END a\n",
+			issues: []MarkerIssue{
+				{Line: 2, Name: "a", Msg: "START a already opened at line 1"},
+			},
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			issues, err := ValidateMarkers([]byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(issues, tt.issues) {
+				t.Errorf("case [%s]: expected issues %v; got %v", tt.name, tt.issues, issues)
+			}
+		})
+	}
+}
+
+func TestCaseInsensitiveMarkers(t *testing.T) {
+	src := "func main() {\n\t// Start a\n\ta()\n\t// end a\n\t// START b\n}\n"
+	files := fakeFetcher{"code.go": src}
+	in := "[embedmd]:# (code.go go a)\n"
+
+	var out bytes.Buffer
+	err := Process(&out, strings.NewReader(in), WithFetcher(files))
+	if want := `1: could not extract content from code.go: could not match "START a"`; err == nil || err.Error() != want {
+		t.Errorf("expected error %q with case sensitive markers; got %v", want, err)
+	}
+
+	out.Reset()
+	if err := Process(&out, strings.NewReader(in), WithFetcher(files), WithCaseInsensitiveMarkers(true)); err != nil {
+		t.Fatal(err)
+	}
+	if want := in + "```go\na()\n```\n"; out.String() != want {
+		t.Errorf("expected output %q; got %q", want, out.String())
+	}
+
+	issues, err := ValidateMarkers([]byte(src), WithCaseInsensitiveMarkers(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MarkerIssue{{Line: 5, Name: "b", Msg: "START b without END"}}
+	if !reflect.DeepEqual(issues, want) {
+		t.Errorf("expected issues %v; got %v", want, issues)
+	}
+
+	if _, err := extract([]byte(src), "A", markers{caseInsensitive: true}); err == nil {
+		t.Errorf("expected sample names to be case sensitive")
+	}
+}
+
+func TestKeepRegionMarkers(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n\nfunc main() {\n\t// [START hello]\n\tfmt.Println(\"hello\")\n\t// [END hello]\n}\n"}
+	in := "[embedmd]:# (code.go go hello)\n"
+	tc := []struct {
+		name string
+		keep bool
+		out  string
+	}{
+		{name: "dropped by default", out: "```go\nfmt.Println(\"hello\")\n```\n"},
+		{name: "kept", keep: true, out: "```go\n// [START hello]\nfmt.Println(\"hello\")\n// [END hello]\n```\n"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(in), WithFetcher(files), WithKeepRegionMarkers(tt.keep)); err != nil {
+				t.Fatal(err)
+			}
+			if want := in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
@@ -15,34 +15,72 @@ package embedmd
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
 )
 
+// commandPrefix starts the lines holding embedmd commands.
+const commandPrefix = "[embedmd]:#"
+
 type commandRunner func(io.Writer, *command) error
 
-func process(out io.Writer, in io.Reader, run commandRunner) error {
-	s := &countingScanner{bufio.NewScanner(in), 0}
+// A commandError is an error parsing a command, after which the rest of the
+// document can still be processed.
+type commandError struct{ err error }
+
+func (e commandError) Error() string { return e.err.Error() }
+
+// process runs the commands found in the markdown read from in, writing the
+// resulting markdown to out. If exact is true, the text that is not generated
+// by the commands is written byte for byte, keeping its line endings. Lines
+// longer than maxLine bytes are an error, unless maxLine is zero. If collect is
+// not nil, it is called with the commands that cannot be parsed, which are
+// then kept as text, instead of failing.
+func process(out io.Writer, in io.Reader, run commandRunner, exact bool, maxLine int, collect func(*LineError)) error {
+	s := &countingScanner{Scanner: newLineScanner(in, maxLine), exact: exact}
+	s.Split(scanLinesWithEOL)
 
 	state := parsingText
 	var err error
 	for state != nil {
 		state, err = state(out, s, run)
+		if cerr, ok := err.(commandError); ok {
+			if collect != nil {
+				collect(&LineError{s.line, cerr.err})
+				state = parsingText
+				continue
+			}
+			err = cerr.err
+		}
 		if err != nil {
-			return fmt.Errorf("%d: %v", s.line, err)
+			return &LineError{s.line, err}
 		}
 	}
 
 	if err := s.Err(); err != nil {
-		return fmt.Errorf("%d: %v", s.line, err)
+		return &LineError{s.line, err}
 	}
 	return nil
 }
 
+// newLineScanner returns a bufio.Scanner reading lines from r, accepting lines
+// of up to max bytes. If max is zero, lines can be of any length.
+func newLineScanner(r io.Reader, max int) *bufio.Scanner {
+	if max <= 0 {
+		max = int(^uint(0) >> 1)
+	}
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, max)
+	return s
+}
+
 type countingScanner struct {
 	*bufio.Scanner
-	line int
+	line  int
+	exact bool // whether Source keeps the original line ending.
 }
 
 func (c *countingScanner) Scan() bool {
@@ -53,9 +91,41 @@ func (c *countingScanner) Scan() bool {
 	return b
 }
 
+func (c *countingScanner) Line() int { return c.line }
+
+// Text returns the last line read, without its line ending.
+func (c *countingScanner) Text() string {
+	return strings.TrimSuffix(strings.TrimSuffix(c.Scanner.Text(), "\n"), "\r")
+}
+
+// Source returns the last line read, as it should be written to the output.
+func (c *countingScanner) Source() string {
+	if c.exact {
+		return c.Scanner.Text()
+	}
+	return c.Text() + "\n"
+}
+
+// scanLinesWithEOL is a bufio.SplitFunc like bufio.ScanLines, but keeping the
+// line endings.
+func scanLinesWithEOL(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i+1], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
 type textScanner interface {
 	Text() string
+	Source() string
 	Scan() bool
+	Line() int
 }
 
 type state func(io.Writer, textScanner, commandRunner) (state, error)
@@ -64,43 +134,163 @@ func parsingText(out io.Writer, s textScanner, run commandRunner) (state, error)
 	if !s.Scan() {
 		return nil, nil // end of file, which is fine.
 	}
+	return handleText(out, s)
+}
+
+func handleText(out io.Writer, s textScanner) (state, error) {
 	switch line := s.Text(); {
-	case strings.HasPrefix(line, "[embedmd]:#"):
+	case strings.HasPrefix(line, commandPrefix):
 		return parsingCmd, nil
 	case strings.HasPrefix(line, "```"):
 		return codeParser{print: true}.parse, nil
 	default:
-		fmt.Fprintln(out, s.Text())
+		fmt.Fprint(out, s.Source())
 		return parsingText, nil
 	}
 }
 
 func parsingCmd(out io.Writer, s textScanner, run commandRunner) (state, error) {
 	line := s.Text()
-	fmt.Fprintln(out, line)
+	fmt.Fprint(out, s.Source())
 	args := line[strings.Index(line, "#")+1:]
 	cmd, err := parseCommand(args)
 	if err != nil {
-		return nil, err
+		return nil, commandError{err}
 	}
+	cmd.line = s.Line()
+	cmd.args = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(args), "("), ")")
 	if err := run(out, cmd); err != nil {
 		return nil, err
 	}
+	if cmd.gomod {
+		return parsingVersion(cmd), nil
+	}
+	return parsingCaption(cmd), nil
+}
+
+// parsingCaption returns a state skipping the caption added by
+// WithLinkedCaption for the path of cmd by a previous run, if any.
+func parsingCaption(cmd *command) state {
+	return func(out io.Writer, s textScanner, run commandRunner) (state, error) {
+		if !s.Scan() {
+			return nil, nil // end of file, which is fine.
+		}
+		line := s.Text()
+		for _, p := range []string{cmd.path, cmd.shownPath} {
+			if p != "" && strings.HasPrefix(line, "["+p+"](") && strings.HasSuffix(line, ")") {
+				return parsingOutput(cmd), nil
+			}
+		}
+		return handleOutput(out, s, cmd)
+	}
+}
+
+// parsingVersion returns a state skipping the version written by a previous
+// run for cmd, a gomod command, or the other content generated for it.
+func parsingVersion(cmd *command) state {
+	return func(out io.Writer, s textScanner, run commandRunner) (state, error) {
+		if !s.Scan() {
+			return nil, nil // end of file, which is fine.
+		}
+		if versionRE.MatchString(s.Text()) {
+			return parsingText, nil
+		}
+		return handleOutput(out, s, cmd)
+	}
+}
+
+// parsingOutput returns a state skipping the content generated by a previous
+// run for cmd, the command that was just executed.
+func parsingOutput(cmd *command) state {
+	return func(out io.Writer, s textScanner, run commandRunner) (state, error) {
+		if !s.Scan() {
+			return nil, nil // end of file, which is fine.
+		}
+		return handleOutput(out, s, cmd)
+	}
+}
+
+// handleOutput handles the line already read as generated by a previous run
+// for cmd, or as text if it is not.
+func handleOutput(out io.Writer, s textScanner, cmd *command) (state, error) {
+	switch line := s.Text(); {
+	case strings.HasPrefix(line, "```"):
+		return codeParser{print: false}.parse, nil
+	case strings.HasPrefix(line, "|") && (cmd.table || cmd.structDoc != ""):
+		return parsingTable, nil
+	case strings.HasPrefix(line, missingPrefix):
+		// drop the comment left by a previous run for a missing file.
+		return parsingText, nil
+	case strings.HasPrefix(line, mtimePrefix):
+		return parsingOutput(cmd), nil
+	case strings.HasPrefix(line, sourceMapBegin):
+		return parsingSourceMap, nil
+	case strings.HasPrefix(line, detailsOpen) && cmd.collapse != "" && !cmd.gomod:
+		return parsingDetails, nil
+	case line == rawBegin:
+		return parsingRaw, nil
+	default:
+		fmt.Fprint(out, s.Source())
+		return parsingText, nil
+	}
+}
+
+// parsingTable skips the rows of a table generated by a previous run.
+func parsingTable(out io.Writer, s textScanner, run commandRunner) (state, error) {
 	if !s.Scan() {
 		return nil, nil // end of file, which is fine.
 	}
-	if strings.HasPrefix(s.Text(), "```") {
-		return codeParser{print: false}.parse, nil
+	if strings.HasPrefix(s.Text(), "|") {
+		return parsingTable, nil
+	}
+	return parsedLine, nil
+}
+
+// parsingSourceMap skips the content generated by a previous run between the
+// comments added by WithSourceMap.
+func parsingSourceMap(out io.Writer, s textScanner, run commandRunner) (state, error) {
+	if !s.Scan() {
+		return nil, fmt.Errorf("unbalanced source map comment")
+	}
+	if strings.HasPrefix(s.Text(), sourceMapEnd) {
+		return parsingText, nil
+	}
+	return parsingSourceMap, nil
+}
+
+// parsingRaw skips the content embedded raw by a previous run, up to the
+// comment ending it.
+func parsingRaw(out io.Writer, s textScanner, run commandRunner) (state, error) {
+	if !s.Scan() {
+		return nil, fmt.Errorf("unbalanced raw content comment")
+	}
+	if s.Text() == rawEnd {
+		return parsingSummary, nil
+	}
+	return parsingRaw, nil
+}
+
+// parsingDetails skips the collapsible content generated by a previous run.
+func parsingDetails(out io.Writer, s textScanner, run commandRunner) (state, error) {
+	if !s.Scan() {
+		return nil, fmt.Errorf("unbalanced details element")
 	}
-	fmt.Fprintln(out, s.Text())
-	return parsingText, nil
+	if strings.HasPrefix(s.Text(), detailsClose) {
+		return parsingText, nil
+	}
+	return parsingDetails, nil
+}
+
+// parsedLine handles the line already read by the previous state as text.
+func parsedLine(out io.Writer, s textScanner, run commandRunner) (state, error) {
+	return handleText(out, s)
 }
 
 type codeParser struct{ print bool }
 
 func (c codeParser) parse(out io.Writer, s textScanner, run commandRunner) (state, error) {
 	if c.print {
-		fmt.Fprintln(out, s.Text())
+		fmt.Fprint(out, s.Source())
 	}
 	if !s.Scan() {
 		return nil, fmt.Errorf("unbalanced code section")
@@ -111,7 +301,47 @@ func (c codeParser) parse(out io.Writer, s textScanner, run commandRunner) (stat
 
 	// print the end of the code section if needed and go back to parsing text.
 	if c.print {
-		fmt.Fprintln(out, s.Text())
+		fmt.Fprint(out, s.Source())
+		return parsingText, nil
 	}
-	return parsingText, nil
+	return parsingSummary, nil
+}
+
+// parsingSummary skips the lines added after a code section by a previous run:
+// the comment added by WithBlockSummary, and the links added by WithPreview and
+// WithPlaygroundLink.
+func parsingSummary(out io.Writer, s textScanner, run commandRunner) (state, error) {
+	if !s.Scan() {
+		return nil, nil // end of file, which is fine.
+	}
+	switch line := s.Text(); {
+	case blockSummaryRE.MatchString(line), previewLinkRE.MatchString(line), playgroundLinkRE.MatchString(line):
+		return parsingSummary, nil
+	}
+	return parsedLine, nil
+}
+
+// ValidateOutput checks that every code fence opened in the given markdown,
+// such as the output of Process, is closed. The returned *LineError gives the
+// line of the fence left open.
+func ValidateOutput(r io.Reader) error {
+	s := newLineScanner(r, 0)
+	open := 0 // line of the open fence, if any.
+	for line := 1; s.Scan(); line++ {
+		if !strings.HasPrefix(s.Text(), "```") {
+			continue
+		}
+		if open == 0 {
+			open = line
+		} else {
+			open = 0
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if open != 0 {
+		return &LineError{open, errors.New("unbalanced code fence")}
+	}
+	return nil
 }
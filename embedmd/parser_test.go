@@ -0,0 +1,61 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestValidateOutput(t *testing.T) {
+	tc := []struct {
+		name string
+		in   string
+		err  string
+	}{
+		{name: "no fences", in: "# Title\n\ntext\n"},
+		{name: "balanced", in: "```go\npackage main\n```\n\n```\n$ go test\n```\n"},
+		{name: "unbalanced", in: "```go\npackage main\n```\n\ntext\n```sh\n$ go test\n", err: "6: unbalanced code fence"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateOutput(strings.NewReader(tt.in))
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("case [%s]: unexpected error %v", tt.name, err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+			}
+			if le, ok := err.(*LineError); !ok || le.Line != 6 {
+				t.Errorf("case [%s]: expected a *LineError at line 6; got %#v", tt.name, err)
+			}
+		})
+	}
+}
+
+func TestValidateProcessOutput(t *testing.T) {
+	in := "[embedmd]:# (code.go raw)\n"
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithFetcher(fakeFetcher{"code.go": "```\n"})); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateOutput(&out); err == nil {
+		t.Errorf("expected an error for the unbalanced raw fence")
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultPlaygroundURL is the Go Playground sharing the snippets linked with
+// WithPlaygroundLink.
+const defaultPlaygroundURL = "https://play.golang.org"
+
+// WithPlaygroundLink adds after embedded complete Go files, those with a package
+// clause, a link running them in the Go Playground. The code is shared on the
+// playground when processing; if that fails, a warning is logged and the link
+// is omitted.
+func WithPlaygroundLink(link bool) Option {
+	return Option{func(e *embedder) { e.playgroundLink = link }}
+}
+
+// playgroundLinkRE matches the links added by WithPlaygroundLink.
+var playgroundLinkRE = regexp.MustCompile(`^\[Run in the Go Playground\]\(.*\)$`)
+
+// playgroundTimeout bounds the time taken to share a snippet.
+const playgroundTimeout = 10 * time.Second
+
+// sharePlayground shares the given Go code on the playground and returns the
+// URL running it. The request is sent like the ones of the default Fetcher, so
+// it goes through the proxy, host overrides and timeouts given as options.
+func (e *embedder) sharePlayground(code string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), playgroundTimeout)
+	defer cancel()
+	f := e.defaultFetcher().(fetcher)
+	id, err := f.post(ctx, e.playgroundURL+"/share", "text/plain; charset=utf-8", strings.NewReader(code))
+	if err != nil {
+		return "", err
+	}
+	if len(id) == 0 || strings.ContainsAny(string(id), " \t\r\n/") {
+		return "", fmt.Errorf("bad snippet id %q", id)
+	}
+	return e.playgroundURL + "/p/" + string(id), nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPlaygroundLink(t *testing.T) {
+	var shared []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/share" {
+			http.NotFound(w, r)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		shared = append(shared, string(b))
+		w.Write([]byte("abc123"))
+	}))
+	defer srv.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	files := fakeFetcher{
+		"main.go": "package main\n\nfunc main() {}\n",
+		"frag.go": "package main\n\n// START f\nfmt.Println(1)\n// END f\n",
+	}
+	tc := []struct {
+		name   string
+		url    string
+		opts   []Option
+		in     string
+		out    string
+		shared []string
+		log    string
+	}{
+		{
+			name:   "complete file",
+			url:    srv.URL,
+			in:     "[embedmd]:# (main.go)\n",
+			out:    "```go\npackage main\n\nfunc main() {}\n```\n[Run in the Go Playground](" + srv.URL + "/p/abc123)\n",
+			shared: []string{"package main\n\nfunc main() {}\n"},
+		},
+		{
+			name:   "host override",
+			url:    "http://play.example",
+			opts:   []Option{WithHostOverride(map[string]string{"play.example": strings.TrimPrefix(srv.URL, "http://")})},
+			in:     "[embedmd]:# (main.go)\n",
+			out:    "```go\npackage main\n\nfunc main() {}\n```\n[Run in the Go Playground](http://play.example/p/abc123)\n",
+			shared: []string{"package main\n\nfunc main() {}\n"},
+		},
+		{
+			name: "fragment",
+			url:  srv.URL,
+			in:   "[embedmd]:# (frag.go go f)\n",
+			out:  "```go\nfmt.Println(1)\n```\n",
+		},
+		{
+			name: "playground failure",
+			url:  down.URL,
+			in:   "[embedmd]:# (main.go)\n",
+			out:  "```go\npackage main\n\nfunc main() {}\n```\n",
+			log:  "1: could not share main.go on the Go Playground: status 503 Service Unavailable\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			shared = nil
+			var logs bytes.Buffer
+			opts := append([]Option{
+				WithFetcher(files), WithLogger(log.New(&logs, "", 0)), WithPlaygroundLink(true),
+				{func(e *embedder) { e.playgroundURL = tt.url }},
+			}, tt.opts...)
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(tt.in), opts...); err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+			if logs.String() != tt.log {
+				t.Errorf("case [%s]: expected warning %q; got %q", tt.name, tt.log, logs.String())
+			}
+			if strings.Join(shared, "|") != strings.Join(tt.shared, "|") {
+				t.Errorf("case [%s]: expected sharing %q; got %q", tt.name, tt.shared, shared)
+			}
+
+			// The link is replaced when processing the output again.
+			var again bytes.Buffer
+			if err := Process(&again, strings.NewReader(out.String()), opts...); err != nil {
+				t.Fatal(err)
+			}
+			if again.String() != out.String() {
+				t.Errorf("case [%s]: expected output %q when processing again; got %q", tt.name, out.String(), again.String())
+			}
+		})
+	}
+}
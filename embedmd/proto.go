@@ -0,0 +1,116 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// extractProtoMessage returns the lines defining the protocol buffer message
+// with the given name. Nested messages are named after their parents, as in
+// Outer.Inner.
+func extractProtoMessage(b []byte, name string) ([]byte, error) {
+	type scope struct {
+		name  string // empty for blocks other than messages.
+		start int    // offset of the message keyword.
+	}
+	var stack []scope
+
+	toks := protoTokens(b)
+	for i, tok := range toks {
+		switch tok.text {
+		case "{":
+			s := scope{}
+			if i >= 2 && toks[i-2].text == "message" {
+				s = scope{toks[i-1].text, toks[i-2].pos}
+			}
+			stack = append(stack, s)
+		case "}":
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("unbalanced braces")
+			}
+			var names []string
+			for _, s := range stack {
+				if s.name != "" {
+					names = append(names, s.name)
+				}
+			}
+			s := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if s.name == "" || strings.Join(names, ".") != name {
+				continue
+			}
+
+			from := bytes.LastIndexByte(b[:s.start], '\n') + 1
+			if nl := bytes.IndexByte(b[tok.pos:], '\n'); nl >= 0 {
+				return b[from : tok.pos+nl+1], nil
+			}
+			return b[from:], nil
+		}
+	}
+	return nil, fmt.Errorf("could not find message %s", name)
+}
+
+type protoToken struct {
+	text string
+	pos  int
+}
+
+// protoTokens splits protocol buffer definitions into identifiers and
+// punctuation, skipping blanks, comments, and string literals.
+func protoTokens(b []byte) []protoToken {
+	var toks []protoToken
+	for i := 0; i < len(b); {
+		c := b[i]
+		switch {
+		case bytes.HasPrefix(b[i:], []byte("//")):
+			nl := bytes.IndexByte(b[i:], '\n')
+			if nl < 0 {
+				return toks
+			}
+			i += nl + 1
+		case bytes.HasPrefix(b[i:], []byte("/*")):
+			end := bytes.Index(b[i+2:], []byte("*/"))
+			if end < 0 {
+				return toks
+			}
+			i += end + 4
+		case c == '"' || c == '\'':
+			for i++; i < len(b) && b[i] != c; i++ {
+				if b[i] == '\\' {
+					i++
+				}
+			}
+			i++
+		case isProtoIdent(c):
+			start := i
+			for i < len(b) && isProtoIdent(b[i]) {
+				i++
+			}
+			toks = append(toks, protoToken{string(b[start:i]), start})
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		default:
+			toks = append(toks, protoToken{string(c), i})
+			i++
+		}
+	}
+	return toks
+}
+
+func isProtoIdent(c byte) bool {
+	return c == '_' || c == '.' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
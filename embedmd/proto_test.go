@@ -0,0 +1,88 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"strings"
+	"testing"
+)
+
+const protoContent = `syntax = "proto3";
+
+// User is a top level message.
+message User {
+  string name = 1; // a } in a comment
+  string message = 2;
+}
+
+message Group {
+  /* members { of the group */
+  message User {
+    string id = 1;
+    string role = 2 [json_name = "r}"];
+  }
+  repeated User users = 1;
+  oneof kind {
+    string team = 2;
+  }
+}
+`
+
+func TestExtractProtoMessage(t *testing.T) {
+	tc := []struct {
+		name    string
+		message string
+		out     string
+		err     string
+	}{
+		{
+			name:    "top level message",
+			message: "User",
+			out:     "message User {\n  string name = 1; // a } in a comment\n  string message = 2;\n}\n",
+		},
+		{
+			name:    "nested message with the same name",
+			message: "Group.User",
+			out:     "  message User {\n    string id = 1;\n    string role = 2 [json_name = \"r}\"];\n  }\n",
+		},
+		{
+			name:    "message with nested blocks",
+			message: "Group",
+			out:     protoContent[strings.Index(protoContent, "message Group"):],
+		},
+		{
+			name:    "unknown message",
+			message: "Team",
+			err:     "could not find message Team",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := extractProtoMessage([]byte(protoContent), tt.message)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.out {
+				t.Errorf("case [%s]: expected extracting %q; got %q", tt.name, tt.out, b)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import "io"
+
+// A Report describes the result of processing a markdown document, and can be
+// encoded as JSON for other tools to consume.
+type Report struct {
+	Directives []DirectiveReport `json:"directives"`
+	Errors     []string          `json:"errors"`
+}
+
+// A DirectiveReport describes an embedmd command that was executed.
+type DirectiveReport struct {
+	Line  int    `json:"line"`  // line of the command in the document.
+	Path  string `json:"path"`  // path or URL of the embedded content.
+	Lang  string `json:"lang"`  // language of the code block.
+	Bytes int    `json:"bytes"` // size of the extracted content.
+}
+
+// ProcessReport behaves like Process, and also returns a Report describing
+// the commands executed and the errors found. When WithCollectErrors is used,
+// the report contains every error, otherwise only the first one.
+func ProcessReport(out io.Writer, in io.Reader, opts ...Option) (Report, error) {
+	r := Report{Directives: []DirectiveReport{}, Errors: []string{}}
+	opts = append(opts[:len(opts):len(opts)], Option{func(e *embedder) { e.report = &r }})
+	err := Process(out, in, opts...)
+	switch err := err.(type) {
+	case nil:
+	case Errors:
+		for _, e := range err {
+			r.Errors = append(r.Errors, e.Error())
+		}
+	default:
+		r.Errors = append(r.Errors, err.Error())
+	}
+	return r, err
+}
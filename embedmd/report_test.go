@@ -0,0 +1,56 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestProcessReport(t *testing.T) {
+	files := fakeFetcher{
+		"code.go": "package main\n",
+		"run.sh":  "#!/bin/sh\n// START a\necho hello\n// END a\n",
+	}
+	in := "# doc\n[embedmd]:# (code.go)\n\n[embedmd]:# (run.sh bash a)\n\n[embedmd]:# (missing.go)\n"
+
+	r, err := ProcessReport(ioutil.Discard, strings.NewReader(in), WithFetcher(files), WithCollectErrors(true))
+	if err == nil {
+		t.Fatal("expected an error for missing.go")
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"directives":[` +
+		`{"line":2,"path":"code.go","lang":"go","bytes":13},` +
+		`{"line":4,"path":"run.sh","lang":"bash","bytes":28}],` +
+		`"errors":["6: could not read missing.go: file does not exist"]}`
+	if string(b) != want {
+		t.Errorf("expected report\n%s\ngot\n%s", want, b)
+	}
+}
+
+func TestProcessReportKeepsOptions(t *testing.T) {
+	opts := append(make([]Option, 0, 2), WithFetcher(fakeFetcher{"code.go": "package main\n"}))
+	if _, err := ProcessReport(ioutil.Discard, strings.NewReader("[embedmd]:# (code.go)\n"), opts...); err != nil {
+		t.Fatal(err)
+	}
+	if spare := opts[:2][1]; spare.f != nil {
+		t.Errorf("expected the options of the caller to be left untouched")
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// extractStructDoc returns, as CSV with a header record, the exported fields
+// of the Go struct type with the given name: their name, type, tag and doc
+// comment. Fields of embedded structs declared in the same file are listed in
+// place of the embedded field, as they are promoted, unless a json tag gives
+// the embedded field a name of its own.
+func extractStructDoc(b []byte, name string) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", b, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	structs := map[string]*ast.StructType{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if ts, ok := n.(*ast.TypeSpec); ok {
+			if st, ok := ts.Type.(*ast.StructType); ok {
+				structs[ts.Name.Name] = st
+			}
+		}
+		return true
+	})
+	if structs[name] == nil {
+		return nil, fmt.Errorf("could not find struct %s", name)
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"Field", "Type", "Tag", "Description"})
+	if err := writeStructFields(w, structs, name, map[string]bool{}); err != nil {
+		return nil, err
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// writeStructFields writes a record for every exported field of the named
+// struct, recursing into embedded structs not already being written.
+func writeStructFields(w *csv.Writer, structs map[string]*ast.StructType, name string, seen map[string]bool) error {
+	seen[name] = true
+	defer delete(seen, name)
+	for _, field := range structs[name].Fields.List {
+		typ := types.ExprString(field.Type)
+		var tag string
+		if field.Tag != nil {
+			t, err := strconv.Unquote(field.Tag.Value)
+			if err != nil {
+				return err
+			}
+			tag = t
+		}
+		doc := field.Doc.Text()
+		if doc == "" {
+			doc = field.Comment.Text()
+		}
+		doc = strings.Join(strings.Fields(doc), " ")
+
+		names := make([]string, len(field.Names))
+		for i, n := range field.Names {
+			names[i] = n.Name
+		}
+		if len(names) == 0 {
+			embedded := strings.TrimPrefix(typ, "*")
+			local := !strings.Contains(embedded, ".")
+			if !local {
+				embedded = embedded[strings.LastIndex(embedded, ".")+1:]
+			}
+			if _, ok := structs[embedded]; ok && local && !seen[embedded] && reflect.StructTag(tag).Get("json") == "" {
+				if err := writeStructFields(w, structs, embedded, seen); err != nil {
+					return err
+				}
+				continue
+			}
+			names = []string{embedded}
+		}
+		for _, n := range names {
+			if ast.IsExported(n) {
+				w.Write([]string{n, typ, tag, doc})
+			}
+		}
+	}
+	return nil
+}
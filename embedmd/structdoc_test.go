@@ -0,0 +1,98 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const structDocContent = `package config
+
+import "time"
+
+// Config configures the server.
+type Config struct {
+	// Addr is the address to listen on.
+	Addr string ` + "`json:\"addr\"`" + `
+	Timeout time.Duration ` + "`json:\"timeout,omitempty\"`" + ` // Timeout of requests.
+	Base
+	TLS  *TLS ` + "`json:\"tls\"`" + `
+	Min, Max int // Bounds of the pool.
+	secret string
+}
+
+// Base holds common settings.
+type Base struct {
+	// Name of the service, shown in logs
+	// and metrics.
+	Name string
+}
+
+type TLS struct {
+	Cert string
+}
+`
+
+func TestStructDoc(t *testing.T) {
+	tc := []struct {
+		name string
+		typ  string
+		out  string
+		err  string
+	}{
+		{
+			name: "documented fields",
+			typ:  "Config",
+			out: "| Field | Type | Tag | Description |\n| --- | --- | --- | --- |\n" +
+				"| Addr | string | json:\"addr\" | Addr is the address to listen on. |\n" +
+				"| Timeout | time.Duration | json:\"timeout,omitempty\" | Timeout of requests. |\n" +
+				"| Name | string |  | Name of the service, shown in logs and metrics. |\n" +
+				"| TLS | *TLS | json:\"tls\" |  |\n" +
+				"| Min | int |  | Bounds of the pool. |\n" +
+				"| Max | int |  | Bounds of the pool. |\n",
+		},
+		{
+			name: "undocumented fields",
+			typ:  "TLS",
+			out:  "| Field | Type | Tag | Description |\n| --- | --- | --- | --- |\n| Cert | string |  |  |\n",
+		},
+		{
+			name: "missing struct",
+			typ:  "Server",
+			err:  "1: could not extract content from config.go: could not find struct Server",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			in := "[embedmd]:# (config.go structdoc=" + tt.typ + ")\n"
+			var out bytes.Buffer
+			err := Process(&out, strings.NewReader(in), WithFetcher(fakeFetcher{"config.go": structDocContent}))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
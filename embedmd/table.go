@@ -0,0 +1,54 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// writeTable writes the given CSV content as a markdown table, using the
+// first record as the header.
+func writeTable(w io.Writer, b []byte) error {
+	records, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil {
+		return fmt.Errorf("could not parse CSV: %v", err)
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("could not parse CSV: no header")
+	}
+
+	writeRow(w, records[0])
+	sep := make([]string, len(records[0]))
+	for i := range sep {
+		sep[i] = "---"
+	}
+	writeRow(w, sep)
+	for _, r := range records[1:] {
+		writeRow(w, r)
+	}
+	return nil
+}
+
+var cellReplacer = strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>")
+
+func writeRow(w io.Writer, cells []string) {
+	for i, c := range cells {
+		cells[i] = cellReplacer.Replace(c)
+	}
+	fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | "))
+}
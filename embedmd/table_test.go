@@ -0,0 +1,93 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTable(t *testing.T) {
+	files := fakeFetcher{
+		"simple.csv": "name,age\nGopher,7\nFerris,3\n",
+		"quoted.csv": "name,description\n\"Doe, Jane\",\"likes \"\"quotes\"\" | pipes\"\n",
+		"broken.csv": "a,b\n\"unterminated\n",
+	}
+	tc := []struct {
+		name string
+		path string
+		out  string
+		err  string
+	}{
+		{
+			name: "simple",
+			path: "simple.csv",
+			out:  "| name | age |\n| --- | --- |\n| Gopher | 7 |\n| Ferris | 3 |\n",
+		},
+		{
+			name: "quoted commas",
+			path: "quoted.csv",
+			out:  "| name | description |\n| --- | --- |\n| Doe, Jane | likes \"quotes\" \\| pipes |\n",
+		},
+		{
+			name: "invalid CSV",
+			path: "broken.csv",
+			err:  "2: could not parse CSV: ",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			in := "[embedmd]:# (" + tt.path + " table)\n"
+			var out bytes.Buffer
+			err := Process(&out, strings.NewReader("# Data\n"+in+"\nafter\n"), WithFetcher(files))
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Fatalf("case [%s]: expected error starting with %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "# Data\n" + in + tt.out + "\nafter\n"
+			if out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+
+			// processing the output again replaces the table.
+			var again bytes.Buffer
+			if err := Process(&again, strings.NewReader(out.String()), WithFetcher(files)); err != nil {
+				t.Fatal(err)
+			}
+			if again.String() != want {
+				t.Errorf("case [%s]: expected stable output %q; got %q", tt.name, want, again.String())
+			}
+		})
+	}
+}
+
+func TestTableAfterCommand(t *testing.T) {
+	files := fakeFetcher{"a.go": "package a\n"}
+	in := "[embedmd]:# (a.go)\n| a | b |\n"
+	want := "[embedmd]:# (a.go)\n```go\npackage a\n```\n| a | b |\n"
+	var out bytes.Buffer
+	if err := Process(&out, strings.NewReader(in), WithFetcher(files)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("expected the table following the command to be kept in %q; got %q", want, out.String())
+	}
+}
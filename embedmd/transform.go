@@ -0,0 +1,111 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A Transformer modifies the lines of embedded content. Transformers are
+// applied once the content is extracted, normalized and reindented, before
+// the lines are prefixed and written.
+type Transformer interface {
+	Transform(lines []string) ([]string, error)
+}
+
+// A TransformerFunc is a function used as a Transformer.
+type TransformerFunc func(lines []string) ([]string, error)
+
+// Transform calls f(lines).
+func (f TransformerFunc) Transform(lines []string) ([]string, error) { return f(lines) }
+
+// WithTransformers applies the given transformers, in order, to the content
+// embedded by every command.
+func WithTransformers(ts ...Transformer) Option {
+	return Option{func(e *embedder) { e.transformers = append(e.transformers, ts...) }}
+}
+
+// Grep returns a Transformer keeping only the lines matching re.
+func Grep(re *regexp.Regexp) Transformer {
+	return TransformerFunc(func(lines []string) ([]string, error) {
+		var res []string
+		for _, line := range lines {
+			if re.MatchString(line) {
+				res = append(res, line)
+			}
+		}
+		return res, nil
+	})
+}
+
+// Reindent returns a Transformer indenting every non empty line by n spaces.
+func Reindent(n int) Transformer {
+	return TransformerFunc(func(lines []string) ([]string, error) {
+		return reindent(lines, n), nil
+	})
+}
+
+// Prefix returns a Transformer adding the given prefix to every line.
+func Prefix(prefix string) Transformer {
+	return TransformerFunc(func(lines []string) ([]string, error) {
+		for i, line := range lines {
+			lines[i] = prefix + line
+		}
+		return lines, nil
+	})
+}
+
+// TrimTrailingSpace returns a Transformer removing the blanks ending lines.
+func TrimTrailingSpace() Transformer {
+	return TransformerFunc(func(lines []string) ([]string, error) {
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(line, " \t")
+		}
+		return lines, nil
+	})
+}
+
+// StripCommentPrefix returns a Transformer removing the comment prefix shared
+// by all the non empty lines, as WithStripCommentPrefix does.
+func StripCommentPrefix() Transformer {
+	return TransformerFunc(func(lines []string) ([]string, error) {
+		return stripCommentPrefix(lines), nil
+	})
+}
+
+// Sort returns a Transformer sorting the lines, as the sort keyword does.
+func Sort() Transformer {
+	return TransformerFunc(func(lines []string) ([]string, error) {
+		sort.Strings(lines)
+		return lines, nil
+	})
+}
+
+// Uniq returns a Transformer removing the lines already seen, keeping the first
+// of each, as the uniq keyword does.
+func Uniq() Transformer {
+	return TransformerFunc(func(lines []string) ([]string, error) {
+		seen := make(map[string]bool)
+		res := lines[:0]
+		for _, line := range lines {
+			if !seen[line] {
+				seen[line] = true
+				res = append(res, line)
+			}
+		}
+		return res, nil
+	})
+}
@@ -0,0 +1,108 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTransformers(t *testing.T) {
+	files := fakeFetcher{"code.go": "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(1)  \n\tfmt.Println(2)\n}\n"}
+	tc := []struct {
+		name string
+		ts   []Transformer
+		out  string
+		err  string
+	}{
+		{
+			name: "grep and reindent",
+			ts:   []Transformer{Grep(regexp.MustCompile(`Println`)), TrimTrailingSpace(), Reindent(2)},
+			out:  "```go\n  \tfmt.Println(1)\n  \tfmt.Println(2)\n```\n",
+		},
+		{
+			name: "order matters",
+			ts:   []Transformer{Prefix("// "), Grep(regexp.MustCompile(`^func`))},
+			out:  "```go\n```\n",
+		},
+		{
+			name: "comment prefix",
+			ts:   []Transformer{Grep(regexp.MustCompile(`^import|^package`)), Prefix("// "), StripCommentPrefix()},
+			out:  "```go\npackage main\nimport \"fmt\"\n```\n",
+		},
+		{
+			name: "error",
+			ts: []Transformer{TransformerFunc(func([]string) ([]string, error) {
+				return nil, errors.New("boom")
+			})},
+			err: "1: could not transform content from code.go: boom",
+		},
+	}
+
+	in := "[embedmd]:# (code.go)\n"
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Process(&out, strings.NewReader(in), WithFetcher(files), WithTransformers(tt.ts...))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("case [%s]: expected error %q; got %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := in + tt.out; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
+
+func TestSortUniq(t *testing.T) {
+	files := fakeFetcher{"keys.txt": "timeout\naddr\n# comment\nretries\naddr\ntimeout\n"}
+	tc := []struct {
+		name string
+		args string
+		ts   []Transformer
+		out  string
+	}{
+		{name: "sort", args: "sort", out: "# comment\naddr\naddr\nretries\ntimeout\ntimeout\n"},
+		{name: "uniq", args: "uniq", out: "timeout\naddr\n# comment\nretries\n"},
+		{name: "sort and uniq", args: "uniq sort", out: "# comment\naddr\nretries\ntimeout\n"},
+		{
+			name: "after transformers",
+			args: "sort uniq",
+			ts:   []Transformer{Grep(regexp.MustCompile(`^[a-z]`)), Prefix("- ")},
+			out:  "- addr\n- retries\n- timeout\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			in := "[embedmd]:# (keys.txt lang=text " + tt.args + ")\n"
+			var out bytes.Buffer
+			if err := Process(&out, strings.NewReader(in), WithFetcher(files), WithTransformers(tt.ts...)); err != nil {
+				t.Fatal(err)
+			}
+			if want := in + "```text\n" + tt.out + "```\n"; out.String() != want {
+				t.Errorf("case [%s]: expected output %q; got %q", tt.name, want, out.String())
+			}
+		})
+	}
+}
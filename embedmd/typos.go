@@ -0,0 +1,89 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"strings"
+)
+
+// A typo is a line that looks like a mistyped command.
+type typo struct {
+	line int
+	text string // the mistyped command.
+	fix  string // the command as it should probably be written.
+}
+
+// findTypos returns the lines of the markdown b, outside of code blocks, that
+// are not commands but that look like one, with a small edit distance to
+// [embedmd]:# before their argument list.
+func findTypos(b []byte) []typo {
+	var typos []typo
+	inCode := false
+	s := newLineScanner(bytes.NewReader(b), 0)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode || strings.HasPrefix(line, commandPrefix) {
+			continue
+		}
+		i := strings.Index(line, "(")
+		if i < 0 {
+			continue
+		}
+		head := strings.TrimSpace(line[:i])
+		if head == "" || len(head) > 2*len(commandPrefix) {
+			continue
+		}
+		if levenshtein(head, commandPrefix) <= 2 {
+			typos = append(typos, typo{n, line, commandPrefix + " " + line[i:]})
+		}
+	}
+	return typos
+}
+
+// levenshtein returns the number of single byte insertions, deletions and
+// substitutions needed to change a into b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedmd
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestWarnOnTypos(t *testing.T) {
+	in := strings.Join([]string{
+		"[embedmd]: (code.go)",
+		"[embemd]:# (code.go)",
+		" [embedmd]:# (code.go)",
+		"[embedmd]:# (code.go)",
+		"",
+		"```",
+		"[embedmd]: (inside.go)",
+		"```",
+		"A link [example](https://example.com).",
+		"[link]: (https://example.com)",
+		"",
+	}, "\n")
+	files := fakeFetcher{"code.go": "package main\n"}
+
+	var logs, out bytes.Buffer
+	logger := log.New(&logs, "", 0)
+	if err := Process(&out, strings.NewReader(in), WithFetcher(files), WithLogger(logger), WithWarnOnTypos(true)); err != nil {
+		t.Fatal(err)
+	}
+	want := `1: "[embedmd]: (code.go)" looks like a mistyped embedmd command, did you mean "[embedmd]:# (code.go)"?
+2: "[embemd]:# (code.go)" looks like a mistyped embedmd command, did you mean "[embedmd]:# (code.go)"?
+3: " [embedmd]:# (code.go)" looks like a mistyped embedmd command, did you mean "[embedmd]:# (code.go)"?
+`
+	if logs.String() != want {
+		t.Errorf("expected warnings:\n%s\ngot:\n%s", want, logs.String())
+	}
+	if n := strings.Count(out.String(), "package main"); n != 1 {
+		t.Errorf("expected only the valid command to run; it ran %d times", n)
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tc := []struct {
+		a, b string
+		d    int
+	}{
+		{"", "", 0},
+		{"abc", "abc", 0},
+		{"abc", "ab", 1},
+		{"[embemd]:#", "[embedmd]:#", 1},
+		{"kitten", "sitting", 3},
+	}
+	for _, tt := range tc {
+		if d := levenshtein(tt.a, tt.b); d != tt.d {
+			t.Errorf("levenshtein(%q, %q): expected %d; got %d", tt.a, tt.b, tt.d, d)
+		}
+	}
+}
@@ -37,11 +37,9 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 
-	"github.com/pmezard/go-difflib/difflib"
 	"github.com/rakyll/embedmd/embedmd"
 )
 
@@ -93,16 +91,7 @@ func embed(paths []string, rewrite, doDiff bool) (foundDiff bool, err error) {
 			return false, embedmd.Process(stdout, stdin)
 		}
 
-		var out, in bytes.Buffer
-		if err := embedmd.Process(&out, io.TeeReader(stdin, &in)); err != nil {
-			return false, err
-		}
-		d, err := diff(in.String(), out.String())
-		if err != nil || len(d) == 0 {
-			return false, err
-		}
-		fmt.Fprintf(stdout, "%s", d)
-		return true, nil
+		return embedmd.Diff(stdout, stdin)
 	}
 
 	for _, path := range paths {
@@ -126,15 +115,6 @@ var openFile = func(name string) (file, error) {
 	return os.OpenFile(name, os.O_RDWR, 0666)
 }
 
-func readFile(path string) ([]byte, error) {
-	f, err := openFile(path)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	return ioutil.ReadAll(f)
-}
-
 func processFile(path string, rewrite, doDiff bool) (foundDiff bool, err error) {
 	f, err := openFile(path)
 	if err != nil {
@@ -142,22 +122,14 @@ func processFile(path string, rewrite, doDiff bool) (foundDiff bool, err error)
 	}
 	defer f.Close()
 
-	buf := new(bytes.Buffer)
-	if err := embedmd.Process(buf, f, embedmd.WithBaseDir(filepath.Dir(path))); err != nil {
-		return false, err
+	opts := []embedmd.Option{embedmd.WithBaseDir(filepath.Dir(path)), embedmd.WithIncludeGuard(path)}
+	if doDiff {
+		return embedmd.Diff(stdout, f, opts...)
 	}
 
-	if doDiff {
-		f, err := readFile(path)
-		if err != nil {
-			return false, fmt.Errorf("could not read %s for diff: %v", path, err)
-		}
-		data, err := diff(string(f), buf.String())
-		if err != nil || len(data) == 0 {
-			return false, err
-		}
-		fmt.Fprintf(stdout, "%s", data)
-		return true, nil
+	buf := new(bytes.Buffer)
+	if err := embedmd.Process(buf, f, opts...); err != nil {
+		return false, err
 	}
 
 	if rewrite {
@@ -171,11 +143,3 @@ func processFile(path string, rewrite, doDiff bool) (foundDiff bool, err error)
 	io.Copy(stdout, buf)
 	return false, nil
 }
-
-func diff(a, b string) (string, error) {
-	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
-		A:       difflib.SplitLines(a),
-		B:       difflib.SplitLines(b),
-		Context: 3,
-	})
-}
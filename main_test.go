@@ -50,7 +50,9 @@ func TestEmbedStreams(t *testing.T) {
 		{name: "non empty diff",
 			d:  true,
 			in: "# hello\ntest",
-			out: `@@ -1,2 +1,3 @@
+			out: `--- original
++++ processed
+@@ -1,2 +1,3 @@
  # hello
  test
 +
@@ -98,7 +100,7 @@ func TestEmbedFiles(t *testing.T) {
 		{name: "diffing a single file",
 			in:  "one\ntwo\nthree",
 			d:   true,
-			out: "@@ -1 +1,4 @@\n+one\n+two\n+three\n \n",
+			out: "--- a/docs.md\n+++ b/docs.md\n@@ -1,3 +1,4 @@\n one\n two\n three\n+\n",
 		},
 	}
 